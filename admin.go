@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+const PASSPHRASE_ENV = "MYSTOCK_PASSPHRASE"
+
+var backupCmd = flag.Bool("backup", false, "take a snapshot of both databases and exit")
+var listBackupsCmd = flag.Bool("list-backups", false, "list database snapshots and exit")
+var restoreCmd = flag.String("restore", "", "restore the named snapshot and exit")
+var exportCmd = flag.String("export", "", "write an encrypted ledger export to this file and exit")
+var importCmd = flag.String("import", "", "decrypt a ledger export into a new snapshot and exit")
+var rebuildCmd = flag.String("rebuild-from", "", "load recorded exchange reports from this directory into the daily database and exit")
+var reparseCmd = flag.Bool("reparse", false, "re-parse the archived exchange reports into the daily database and exit")
+var exRightsCmd = flag.String("load-exrights", "", "load ex-rights and ex-dividend events since this YYYY-MM-DD date and exit")
+var retryFetchesCmd = flag.Bool("retry-fetches", false, "let the fetcher try the failed days again, including those it gave up on, and exit")
+var backfillCmd = flag.String("backfill", "", "fetch the monthly history of these comma-separated codes into the daily database and exit")
+
+type AdminRequest struct {
+	Op         string `json:"op"`
+	Name       string `json:"name,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+type AdminReply struct {
+	Snapshots []mydb.Snapshot `json:"snapshots,omitempty"`
+	Saved     string          `json:"saved,omitempty"`
+	Retried   int64           `json:"retried,omitempty"`
+}
+
+// Whether r comes from this machine.
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Snapshots can replace and export the whole ledger, so the ops are open to
+// local clients only; elsewhere use the command line.
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	if !fromLoopback(r) {
+		writeJSONErrResonse(w, "Admin ops are local only", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "POST":
+		doAdmin(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func doAdmin(w http.ResponseWriter, r *http.Request) {
+	var req AdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONErrResonse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Op {
+	case "backup":
+		snap, err := mydb.BackupMyDB()
+		if err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONOKResonse(w, AdminReply{Snapshots: []mydb.Snapshot{snap}})
+	case "list":
+		list, err := mydb.ListSnapshots()
+		if err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONOKResonse(w, AdminReply{Snapshots: list})
+	case "restore":
+		saved, err := mydb.RestoreMyDB(req.Name)
+		if err == mydb.ErrNoSuchSnapshot {
+			writeJSONErrResonse(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONOKResonse(w, AdminReply{Saved: saved.Name})
+	case "retry-fetches":
+		n, err := mydb.RetryFailedFetches()
+		if err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Picked up by the next scheduled fetch, or a refresh.
+		writeJSONOKResonse(w, AdminReply{Retried: n})
+	case "export":
+		if req.Passphrase == "" {
+			writeJSONErrResonse(w, "Empty passphrase", http.StatusBadRequest)
+			return
+		}
+		// Encrypt in full first, so a failure still gets an error reply.
+		var buf bytes.Buffer
+		if err := mydb.ExportLedger(&buf, req.Passphrase); err != nil {
+			fmt.Println("Export failed:", err.Error())
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="ledger.mystock"`)
+		w.Write(buf.Bytes())
+	default:
+		writeJSONErrResonse(w, "No such op code", http.StatusBadRequest)
+	}
+}
+
+func readPassphrase() string {
+	if p := os.Getenv(PASSPHRASE_ENV); p != "" {
+		return p
+	}
+	fmt.Print("Passphrase: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// Run the maintenance command given on the command line, if any. The server
+// may keep running meanwhile; snapshots are taken and restored online.
+func runAdminCommand() (ran bool) {
+	if !*backupCmd && !*listBackupsCmd && *restoreCmd == "" && *exportCmd == "" && *importCmd == "" && *rebuildCmd == "" && !*reparseCmd && *backfillCmd == "" && *exRightsCmd == "" && !*retryFetchesCmd {
+		return false
+	}
+
+	mydb.InitMyDB(conf.DBConfig())
+	defer mydb.CloseMyDB()
+
+	var err error
+	switch {
+	case *backupCmd:
+		var snap mydb.Snapshot
+		snap, err = mydb.BackupMyDB()
+		if err == nil {
+			fmt.Printf("Snapshot %s (%d bytes)\n", snap.Name, snap.Size)
+		}
+	case *listBackupsCmd:
+		var list []mydb.Snapshot
+		list, err = mydb.ListSnapshots()
+		for _, snap := range list {
+			fmt.Printf("%s\t%d bytes\n", snap.Name, snap.Size)
+		}
+	case *restoreCmd != "":
+		var saved mydb.Snapshot
+		saved, err = mydb.RestoreMyDB(*restoreCmd)
+		if err == nil {
+			fmt.Printf("Restored %s. Previous state saved as %s\n", *restoreCmd, saved.Name)
+		}
+	case *exportCmd != "":
+		var f *os.File
+		f, err = os.OpenFile(*exportCmd, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+		if err == nil {
+			err = mydb.ExportLedger(f, readPassphrase())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(*exportCmd)
+			} else {
+				fmt.Println("Exported ledger to", *exportCmd)
+			}
+		}
+	case *importCmd != "":
+		var f *os.File
+		f, err = os.Open(*importCmd)
+		if err == nil {
+			var snap mydb.Snapshot
+			snap, err = mydb.ImportLedger(f, readPassphrase())
+			f.Close()
+			if err == nil {
+				fmt.Printf("Imported as snapshot %s. Use -restore %s to bring it live.\n", snap.Name, snap.Name)
+			}
+		}
+	case *rebuildCmd != "":
+		err = rebuildFromDir(context.Background(), *rebuildCmd)
+	case *reparseCmd:
+		err = rebuildFromDir(context.Background(), conf.ArchiveDir)
+	case *retryFetchesCmd:
+		var n int64
+		n, err = mydb.RetryFailedFetches()
+		if err == nil {
+			fmt.Printf("%d failed fetches will be tried again\n", n)
+		}
+	case *backfillCmd != "":
+		exClient = newExchangeClient(conf)
+		err = backfill(context.Background(), strings.Split(*backfillCmd, ","), conf.BackfillMonths)
+	case *exRightsCmd != "":
+		var from mydb.Date
+		if from, err = mydb.ParseDate(*exRightsCmd); err == nil {
+			exClient = newExchangeClient(conf)
+			sideReports = newSideReports(exClient)
+			err = loadExRightsSince(context.Background(), from)
+		}
+	}
+
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+	}
+	return true
+}
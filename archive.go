@@ -0,0 +1,58 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	mydb "myDatabase"
+)
+
+const ARCHIVE_SUFFIX string = ".json.gz"
+
+// Path of the archived report of market on d, laid out like fileProvider
+// expects.
+func archivePath(dir string, market string, d mydb.Date) string {
+	return filepath.Join(dir, market, d.String()+ARCHIVE_SUFFIX)
+}
+
+// Keep a compressed copy of a fetched report, replacing an older copy of the
+// same day.
+func archiveReport(dir string, market string, d mydb.Date, body []byte) error {
+	path := archivePath(dir, market, d)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write(body)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func readArchived(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mydb "myDatabase"
+)
+
+// Monthly history of one stock, from TWSE STOCK_DAY.
+type TWSEStockReport struct {
+	Stat   string     `json:"stat"`
+	Title  string     `json:"title"`
+	Fields []string   `json:"fields"`
+	Data   [][]string `json:"data"`
+}
+
+// Monthly history of one stock, from TPEx tradingStock.
+type TPExStockReport struct {
+	Stat   string       `json:"stat"`
+	Tables []TPExTables `json:"tables"`
+}
+
+type BackfillRequest struct {
+	Codes  []string `json:"codes"`
+	Months int      `json:"months,omitempty"`
+}
+
+// One backfill at a time; they share the exchanges' rate limit anyway.
+var backfillMu sync.Mutex
+
+// Backfills started from the server, waited on at shutdown.
+var backfillWG sync.WaitGroup
+
+// Empty months in a row before a backfill takes the stock as not yet listed.
+// A suspension of a month or two must not cut off the history before it.
+const BACKFILL_EMPTY_MONTHS = 3
+
+// ROC dates like 113/03/01 or 113年03月01日, sometimes marked with a
+// trailing '*'.
+func parseROCDate(s string) (mydb.Date, error) {
+	str := strings.NewReplacer("年", "/", "月", "/", "日", "").Replace(s)
+	parts := strings.Split(strings.TrimRight(strings.TrimSpace(str), "*"), "/")
+	if len(parts) != 3 {
+		return mydb.Date{}, fmt.Errorf("invalid ROC date %q", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return mydb.Date{}, fmt.Errorf("invalid ROC date %q", s)
+		}
+		nums[i] = n
+	}
+	return mydb.NewDate(nums[0]+1911, nums[1], nums[2]), nil
+}
+
+func parseNum(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", "", -1), 64)
+}
+
+// Parse one monthly row laid out as date, volume, value, open, high, low,
+// close, change, trans. Volume and value are multiplied by unit. Rows
+// without a trade are dropped.
+func parseStockMonthRow(code string, data []string, unit float64) (b Bar, keep bool, err error) {
+	const (
+		IDX_DATE         = 0
+		IDX_VOLUME       = 1
+		IDX_TARANS_VALUE = 2
+		IDX_OPEN         = 3
+		IDX_HIGH         = 4
+		IDX_LOW          = 5
+		IDX_CLOSE        = 6
+		IDX_PRICE_DIFF   = 7
+		IDX_TRANS_NR     = 8
+	)
+	if len(data) <= IDX_TRANS_NR {
+		return b, false, fmt.Errorf("short row of %d fields", len(data))
+	}
+	if strings.Contains(data[IDX_CLOSE], "--") {
+		return b, false, nil
+	}
+
+	dq := mydb.DaliyQuote{}
+	if dq.Date, err = parseROCDate(data[IDX_DATE]); err != nil {
+		return b, false, err
+	}
+	var nums [3]float64
+	for i, idx := range []int{IDX_VOLUME, IDX_TARANS_VALUE, IDX_TRANS_NR} {
+		if nums[i], err = parseNum(data[idx]); err != nil {
+			return b, false, err
+		}
+	}
+	prices := []*mydb.Price{&dq.Open, &dq.High, &dq.Low, &dq.Close}
+	for i, idx := range []int{IDX_OPEN, IDX_HIGH, IDX_LOW, IDX_CLOSE} {
+		if *prices[i], err = mydb.ParsePrice(data[idx]); err != nil {
+			return b, false, err
+		}
+	}
+	dq.Volume = int64(nums[0] * unit)
+	dq.Value = int64(nums[1] * unit)
+	dq.Trans = int(nums[2])
+	return Bar{Code: code, Quote: dq}, true, nil
+}
+
+func parseTWSEStockMonth(code string, body []byte) (bars []Bar, err error) {
+	var report TWSEStockReport
+	if err = json.Unmarshal(body, &report); err != nil {
+		return nil, err
+	}
+	if len(report.Data) == 0 {
+		return nil, ErrNoEntry
+	}
+	// The title reads like "113年03月 2330 台積電 各日成交資訊".
+	name := ""
+	if f := strings.Fields(report.Title); len(f) >= 3 && f[1] == code {
+		name = f[2]
+	}
+	for _, data := range report.Data {
+		b, keep, err := parseStockMonthRow(code, data, 1)
+		if err != nil {
+			return nil, err
+		} else if keep {
+			b.Name = name
+			bars = append(bars, b)
+		}
+	}
+	return bars, nil
+}
+
+// TPEx counts volume in lots of 1000 shares and value in thousand dollars.
+func parseTPExStockMonth(code string, body []byte) (bars []Bar, err error) {
+	var report TPExStockReport
+	if err = json.Unmarshal(body, &report); err != nil {
+		return nil, err
+	}
+	if len(report.Tables) == 0 || len(report.Tables[0].Data) == 0 {
+		return nil, ErrNoEntry
+	}
+	for _, data := range report.Tables[0].Data {
+		b, keep, err := parseStockMonthRow(code, data, 1000)
+		if err != nil {
+			return nil, err
+		} else if keep {
+			bars = append(bars, b)
+		}
+	}
+	return bars, nil
+}
+
+type stockMonthSource struct {
+	market string
+	urlFmt string // formatted with year, month, code
+	parse  func(code string, body []byte) ([]Bar, error)
+}
+
+func stockMonthSources() []stockMonthSource {
+	return []stockMonthSource{
+		{MARKET_TWSE, conf.TWSEStockURL, parseTWSEStockMonth},
+		{MARKET_TPEX, conf.TPExStockURL, parseTPExStockMonth},
+	}
+}
+
+// Bars of code in the month of y/m. The market is tried in turn unless
+// already known, and the one that answered is returned.
+func fetchStockMonth(ctx context.Context, code string, y int, m time.Month, market string) ([]Bar, string, error) {
+	for _, src := range stockMonthSources() {
+		if market != "" && src.market != market {
+			continue
+		}
+		url := fmt.Sprintf(src.urlFmt, y, int(m), code)
+		fmt.Printf("Fetching %s...\n", url)
+		body, err := exClient.GetJSON(ctx, url)
+		if err != nil {
+			return nil, "", err
+		}
+		bars, err := src.parse(code, body)
+		if err == ErrNoEntry {
+			continue
+		}
+		return bars, src.market, err
+	}
+	return nil, market, ErrNoEntry
+}
+
+// backfillStock merges months of history of code into its table, newest
+// month first, and stops once BACKFILL_EMPTY_MONTHS months in a row have no
+// trade. Each month is stored in one transaction. Days already stored are
+// left alone.
+func backfillStock(ctx context.Context, code string, months int) (rowNr int, err error) {
+	if err = mydb.ValidateCode(code); err != nil {
+		return 0, err
+	}
+	now := time.Now().In(taipei)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, taipei)
+	market := ""
+	empty := 0
+	for i := 0; i < months && empty < BACKFILL_EMPTY_MONTHS; i++ {
+		bars, found, err := fetchStockMonth(ctx, code, month.Year(), month.Month(), market)
+		month = month.AddDate(0, -1, 0)
+		if err == ErrNoEntry {
+			// Suspended, before the listing, or no such stock.
+			empty++
+			continue
+		} else if err != nil {
+			return rowNr, err
+		}
+		market = found
+		empty = 0
+		stored, _, err := saveBars(market, bars, false)
+		if err != nil {
+			return rowNr, err
+		}
+		rowNr += stored
+	}
+	fmt.Printf("Backfilled %s: %d days from %s\n", code, rowNr, market)
+	return rowNr, nil
+}
+
+func backfill(ctx context.Context, codes []string, months int) error {
+	backfillMu.Lock()
+	defer backfillMu.Unlock()
+	for _, code := range codes {
+		// A backfill queued behind another may start after shutdown.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := backfillStock(ctx, code, months); err != nil {
+			return fmt.Errorf("backfill %s: %w", code, err)
+		}
+	}
+	return nil
+}
+
+// Start a backfill in the background. It runs until done or shutdown.
+func backfillHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		var req BackfillRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Codes) == 0 {
+			writeJSONErrResonse(w, "No code given", http.StatusBadRequest)
+			return
+		}
+		for _, code := range req.Codes {
+			if err := mydb.ValidateCode(code); err != nil {
+				writeJSONErrResonse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Months <= 0 {
+			req.Months = conf.BackfillMonths
+		}
+		backfillWG.Add(1)
+		go func() {
+			defer backfillWG.Done()
+			if err := backfill(appCtx, req.Codes, req.Months); err != nil {
+				fmt.Println("Backfill failed:", err.Error())
+			}
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
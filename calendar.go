@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	mydb "myDatabase"
+)
+
+// Load exchange holidays and make-up trading days, a JSON list like
+//
+//	[{"date": "2025-01-27", "open": false, "note": "Lunar New Year"}]
+//
+// Entries replace what the calendar learned for the same dates.
+func loadCalendarFile(path string) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && path == DEFAULT_CALENDAR_FILE {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var days []mydb.CalendarDay
+	if err = json.Unmarshal(content, &days); err != nil {
+		return fmt.Errorf("calendar file %s: %w", path, err)
+	}
+	for i := range days {
+		days[i].Source = mydb.CAL_SOURCE_FILE
+	}
+	if err = mydb.SetCalendarDays(days); err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d calendar days from %s\n", len(days), path)
+	return nil
+}
+
+// Learn closures and make-up days from the stored quotes, for history that
+// was migrated or backfilled rather than fetched live.
+func learnCalendarFromQuotes() {
+	learned, err := mydb.LearnFromQuotes()
+	if err != nil {
+		fmt.Printf("Failed to learn the calendar from quotes: %s\n", err.Error())
+		return
+	}
+	if learned > 0 {
+		fmt.Printf("Learned %d calendar days from quotes\n", learned)
+	}
+}
@@ -11,6 +11,10 @@ import (
 const MA5_SKYBLUE string = "rgba(97, 141, 212, 0.6)"
 const MA10_YELLOW string = "rgba(214, 194, 92, 0.6)"
 const MA20_PURPLE string = "rgba(245, 158, 255, 0.6)"
+const FOREIGN_ORANGE string = "rgba(237, 139, 52, 0.6)"
+const TRUST_RED string = "rgba(222, 73, 86, 0.6)"
+const MARGIN_GREEN string = "rgba(64, 168, 92, 0.8)"
+const SHORT_GREY string = "rgba(120, 120, 120, 0.8)"
 
 type DataPoint struct {
 	X string  `json:"x,omitempty"` // Time
@@ -105,6 +109,32 @@ func GenVolumeDataset(volume []DataPoint) Dataset {
 	}
 }
 
+// Net buy bars on their own axis, since they go below zero.
+func GenFlowDataset(name string, flow []DataPoint, color string) Dataset {
+	return Dataset{
+		Type:               "bar",
+		Label:              name,
+		Data:               flow,
+		YAxisID:            "flow",
+		BarPercentage:      0.8,
+		CategoryPercentage: 0.8,
+		BackgroundColor:    color,
+	}
+}
+
+// Balance lines on their own axis.
+func GenBalanceDataset(name string, data []DataPoint, color string) Dataset {
+	return Dataset{
+		Type:        "line",
+		Label:       name,
+		Data:        data,
+		BorderColor: color,
+		Fill:        false,
+		YAxisID:     "margin",
+		BorderWidth: 1,
+	}
+}
+
 func GenGenericDataset(graphType string, graphName string, val []float64, bgColor []string) GenericDataset {
 	return GenericDataset{
 		Type:            graphType,
@@ -115,6 +145,15 @@ func GenGenericDataset(graphType string, graphName string, val []float64, bgColo
 	}
 }
 
+func GenStackedBarDataset(graphName string, val []float64, bgColor string) GenericDataset {
+	return GenericDataset{
+		Type:            "bar",
+		Label:           graphName,
+		Data:            val,
+		BackgroundColor: bgColor,
+	}
+}
+
 func GenBGColor() string {
 	r := rand.Int63n(256)
 	g := rand.Int63n(256)
@@ -183,6 +222,27 @@ func GenCandleStickChartConfig(labels []string, datasets []Dataset) ChartConfig
 	return config
 }
 
+// Add datasets of a secondary axis to a candlestick chart, creating the axis
+// on the right if needed.
+func AddAxisDatasets(config *ChartConfig, axis string, title string, datasets ...Dataset) {
+	config.Data.Datasets = append(config.Data.Datasets, datasets...)
+	scales := config.Options["scales"].(map[string]interface{})
+	if _, exist := scales[axis]; exist {
+		return
+	}
+	scales[axis] = map[string]interface{}{
+		"type":     "linear",
+		"position": "right",
+		"title": map[string]interface{}{
+			"display": false,
+			"text":    title,
+		},
+		"grid": map[string]interface{}{
+			"drawOnChartArea": false,
+		},
+	}
+}
+
 func GenGenericChartConfig(graphType string, labels []string, datasets []GenericDataset) GenericChartConfig {
 	config := GenericChartConfig{Type: graphType}
 
@@ -200,3 +260,20 @@ func GenGenericChartConfig(graphType string, labels []string, datasets []Generic
 
 	return config
 }
+
+// Stack bar datasets of a generic chart on both axes and show the legend.
+func SetStackedScales(config *GenericChartConfig) {
+	config.Options["plugins"] = map[string]interface{}{
+		"legend": map[string]interface{}{
+			"display": true,
+		},
+	}
+	config.Options["scales"] = map[string]interface{}{
+		"x": map[string]interface{}{
+			"stacked": true,
+		},
+		"y": map[string]interface{}{
+			"stacked": true,
+		},
+	}
+}
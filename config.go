@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	mydb "myDatabase"
+)
+
+const DEFAULT_CONFIG_FILE string = "./config.json"
+const FETCH_TIME_FORMAT string = "15:04"
+const DEFAULT_CALENDAR_FILE string = "./calendar.json"
+
+// Settings are taken from, in increasing priority: built-in defaults, the
+// JSON config file, MYSTOCK_* environment variables and command-line flags.
+// The json tag doubles as the flag name.
+type Config struct {
+	Addr   string `json:"addr" env:"MYSTOCK_ADDR" usage:"HTTP listen address"`
+	WebDir string `json:"web-dir" env:"MYSTOCK_WEB_DIR" usage:"directory of the HTML pages"`
+
+	MainDB     string `json:"main-db" env:"MYSTOCK_MAIN_DB" usage:"transaction database file"`
+	DailyDB    string `json:"daily-db" env:"MYSTOCK_DAILY_DB" usage:"daily quote database file"`
+	BackupDir  string `json:"backup-dir" env:"MYSTOCK_BACKUP_DIR" usage:"directory of database snapshots"`
+	BackupKeep int    `json:"backup-keep" env:"MYSTOCK_BACKUP_KEEP" usage:"number of snapshots to keep"`
+	ArchiveDir string `json:"archive-dir" env:"MYSTOCK_ARCHIVE_DIR" usage:"directory of compressed raw exchange reports"`
+
+	FetchDelaySec   float64 `json:"fetch-delay" env:"MYSTOCK_FETCH_DELAY" usage:"seconds between two requests to one exchange"`
+	FetchTimeoutSec float64 `json:"fetch-timeout" env:"MYSTOCK_FETCH_TIMEOUT" usage:"seconds before one request to an exchange is given up"`
+	FetchRetries    int     `json:"fetch-retries" env:"MYSTOCK_FETCH_RETRIES" usage:"retries of a request after a transient failure"`
+	TWSEURL         string  `json:"twse-url" env:"MYSTOCK_TWSE_URL" usage:"TWSE daily report URL, formatted with year, month, day"`
+	TPExURL         string  `json:"tpex-url" env:"MYSTOCK_TPEX_URL" usage:"TPEx daily report URL, formatted with year, month, day"`
+	FetchTime       string  `json:"fetch-time" env:"MYSTOCK_FETCH_TIME" usage:"daily fetch time after market close, HH:MM in Asia/Taipei"`
+	TWSEStockURL    string  `json:"twse-stock-url" env:"MYSTOCK_TWSE_STOCK_URL" usage:"TWSE monthly per-stock URL, formatted with year, month, code"`
+	TPExStockURL    string  `json:"tpex-stock-url" env:"MYSTOCK_TPEX_STOCK_URL" usage:"TPEx monthly per-stock URL, formatted with year, month, code"`
+	TWSEExRightURL  string  `json:"twse-exright-url" env:"MYSTOCK_TWSE_EXRIGHT_URL" usage:"TWSE ex-rights report URL, formatted with year, month, day of start and end"`
+	TPExExRightURL  string  `json:"tpex-exright-url" env:"MYSTOCK_TPEX_EXRIGHT_URL" usage:"TPEx ex-rights report URL, formatted with year, month, day of start and end"`
+	TWSEInstURL     string  `json:"twse-inst-url" env:"MYSTOCK_TWSE_INST_URL" usage:"TWSE institutional trades URL, formatted with year, month, day"`
+	TPExInstURL     string  `json:"tpex-inst-url" env:"MYSTOCK_TPEX_INST_URL" usage:"TPEx institutional trades URL, formatted with year, month, day"`
+	TWSEMarginURL   string  `json:"twse-margin-url" env:"MYSTOCK_TWSE_MARGIN_URL" usage:"TWSE margin balance URL, formatted with year, month, day"`
+	TPExMarginURL   string  `json:"tpex-margin-url" env:"MYSTOCK_TPEX_MARGIN_URL" usage:"TPEx margin balance URL, formatted with year, month, day"`
+	TWSEValueURL    string  `json:"twse-value-url" env:"MYSTOCK_TWSE_VALUE_URL" usage:"TWSE PE, PB and yield URL, formatted with year, month, day"`
+	TPExValueURL    string  `json:"tpex-value-url" env:"MYSTOCK_TPEX_VALUE_URL" usage:"TPEx PE, PB and yield URL, formatted with year, month, day"`
+	ValuationYears  int     `json:"valuation-years" env:"MYSTOCK_VALUATION_YEARS" usage:"years of history a valuation percentile is ranked in"`
+	TWSERevenueURL  string  `json:"twse-revenue-url" env:"MYSTOCK_TWSE_REVENUE_URL" usage:"TWSE latest monthly revenue URL"`
+	TPExRevenueURL  string  `json:"tpex-revenue-url" env:"MYSTOCK_TPEX_REVENUE_URL" usage:"TPEx latest monthly revenue URL"`
+	BackfillMonths  int     `json:"backfill-months" env:"MYSTOCK_BACKFILL_MONTHS" usage:"months of history a backfill loads"`
+	FetchDays       int     `json:"fetch-days" env:"MYSTOCK_FETCH_DAYS" usage:"calendar days back that missing quotes are fetched for"`
+	FetchAttempts   int     `json:"fetch-attempts" env:"MYSTOCK_FETCH_ATTEMPTS" usage:"attempts per day and market before giving up"`
+	CalendarFile    string  `json:"calendar-file" env:"MYSTOCK_CALENDAR_FILE" usage:"JSON list of exchange holidays and make-up trading days"`
+
+	ShowingQDs       int   `json:"showing-days" env:"MYSTOCK_SHOWING_DAYS" usage:"least trading days loaded per scanned stock"`
+	BaseQDsNr        int   `json:"base-days" env:"MYSTOCK_BASE_DAYS" usage:"leading trading days used to seed moving averages"`
+	MinInterestedVol int64 `json:"min-volume" env:"MYSTOCK_MIN_VOLUME" usage:"least last-day volume of a scanner result"`
+	MaxTransmitSize  int   `json:"max-results" env:"MYSTOCK_MAX_RESULTS" usage:"scanner results per reply"`
+}
+
+// Effective configuration, set once at startup.
+var conf Config = defaultConfig()
+
+var configFile = flag.String("config", DEFAULT_CONFIG_FILE, "JSON config file; a missing default file is ignored")
+var printConfig = flag.Bool("print-config", false, "print the effective config and exit")
+
+// Flag values land here first, so that only flags given on the command line
+// override the file and the environment.
+var flagConf Config = defaultConfig()
+
+func defaultConfig() Config {
+	dbConf := mydb.DefaultConfig()
+	return Config{
+		Addr:             ":8080",
+		WebDir:           "./web",
+		MainDB:           dbConf.MainPath,
+		DailyDB:          dbConf.DailyPath,
+		BackupDir:        dbConf.BackupDir,
+		BackupKeep:       dbConf.BackupKeep,
+		ArchiveDir:       "./database/archive",
+		FetchDelaySec:    3,
+		FetchTimeoutSec:  30,
+		FetchRetries:     4,
+		TWSEURL:          "https://www.twse.com.tw/exchangeReport/MI_INDEX?response=json&date=%04d%02d%02d&type=ALLBUT0999",
+		TPExURL:          "https://www.tpex.org.tw/www/zh-tw/afterTrading/otc?date=%04d/%02d/%02d&type=EW&response=json",
+		FetchTime:        "15:00",
+		TWSEStockURL:     "https://www.twse.com.tw/exchangeReport/STOCK_DAY?response=json&date=%04d%02d01&stockNo=%s",
+		TPExStockURL:     "https://www.tpex.org.tw/www/zh-tw/afterTrading/tradingStock?date=%04d/%02d/01&code=%s&response=json",
+		TWSEExRightURL:   "https://www.twse.com.tw/exchangeReport/TWT49U?response=json&strDate=%04d%02d%02d&endDate=%04d%02d%02d",
+		TPExExRightURL:   "https://www.tpex.org.tw/www/zh-tw/bulletin/exDailyQ?startDate=%04d/%02d/%02d&endDate=%04d/%02d/%02d&response=json",
+		TWSEInstURL:      "https://www.twse.com.tw/fund/T86?response=json&date=%04d%02d%02d&selectType=ALLBUT0999",
+		TPExInstURL:      "https://www.tpex.org.tw/www/zh-tw/insti/dailyTrade?type=Daily&sect=EW&date=%04d/%02d/%02d&response=json",
+		TWSEMarginURL:    "https://www.twse.com.tw/exchangeReport/MI_MARGN?response=json&date=%04d%02d%02d&selectType=ALL",
+		TPExMarginURL:    "https://www.tpex.org.tw/www/zh-tw/margin/balance?date=%04d/%02d/%02d&response=json",
+		TWSEValueURL:     "https://www.twse.com.tw/exchangeReport/BWIBBU_d?response=json&date=%04d%02d%02d&selectType=ALL",
+		TPExValueURL:     "https://www.tpex.org.tw/www/zh-tw/afterTrading/peQryDate?date=%04d/%02d/%02d&response=json",
+		ValuationYears:   5,
+		TWSERevenueURL:   "https://openapi.twse.com.tw/v1/opendata/t187ap05_L",
+		TPExRevenueURL:   "https://www.tpex.org.tw/openapi/v1/mopsfin_t187ap05_O",
+		BackfillMonths:   12,
+		FetchDays:        70,
+		FetchAttempts:    5,
+		CalendarFile:     DEFAULT_CALENDAR_FILE,
+		ShowingQDs:       60,
+		BaseQDsNr:        20,
+		MinInterestedVol: 800000, // qty 800,000
+		MaxTransmitSize:  32,
+	}
+}
+
+// Hour and minute of FetchTime, which validate has checked.
+func (c Config) fetchClock() (hour int, min int) {
+	t, _ := time.Parse(FETCH_TIME_FORMAT, c.FetchTime)
+	return t.Hour(), t.Minute()
+}
+
+func (c Config) DBConfig() mydb.Config {
+	return mydb.Config{
+		MainPath:   c.MainDB,
+		DailyPath:  c.DailyDB,
+		BackupDir:  c.BackupDir,
+		BackupKeep: c.BackupKeep,
+	}
+}
+
+func init() {
+	v := reflect.ValueOf(&flagConf).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := f.Tag.Get("json")
+		usage := f.Tag.Get("usage") + " (env " + f.Tag.Get("env") + ")"
+		switch p := v.Field(i).Addr().Interface().(type) {
+		case *string:
+			flag.StringVar(p, name, *p, usage)
+		case *int:
+			flag.IntVar(p, name, *p, usage)
+		case *int64:
+			flag.Int64Var(p, name, *p, usage)
+		case *float64:
+			flag.Float64Var(p, name, *p, usage)
+		}
+	}
+}
+
+func setField(field reflect.Value, str string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(str)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	}
+	return nil
+}
+
+// loadConfig must run after flag.Parse.
+func loadConfig() (c Config, err error) {
+	c = defaultConfig()
+
+	content, err := os.ReadFile(*configFile)
+	if err == nil {
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&c); err != nil {
+			return c, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) || *configFile != DEFAULT_CONFIG_FILE {
+		return c, err
+	}
+
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		env := v.Type().Field(i).Tag.Get("env")
+		if str, ok := os.LookupEnv(env); ok {
+			if err = setField(v.Field(i), str); err != nil {
+				return c, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+
+	fv := reflect.ValueOf(flagConf)
+	flag.Visit(func(f *flag.Flag) {
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("json") == f.Name {
+				v.Field(i).Set(fv.Field(i))
+			}
+		}
+	})
+
+	return c, c.validate()
+}
+
+// Check a URL format taking the given sample arguments, described by takes.
+// A format taking arguments writes a literal % as %%; one taking none is the
+// URL itself, so it may be percent-encoded as is.
+func validateURLFormat(name string, str string, takes string, args ...any) error {
+	formatted := str
+	if len(args) > 0 {
+		formatted = fmt.Sprintf(str, args...)
+		if strings.Contains(formatted, "%!") {
+			return fmt.Errorf("%s must take %s, with a literal %% written %%%%: %q", name, takes, str)
+		}
+	}
+	u, err := url.Parse(formatted)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s is not a http(s) URL: %q", name, str)
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("addr: %w", err)
+	}
+	if info, err := os.Stat(c.WebDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("web-dir %q is not a directory", c.WebDir)
+	}
+	if c.MainDB == "" || c.DailyDB == "" || c.BackupDir == "" || c.ArchiveDir == "" {
+		return errors.New("database paths must not be empty")
+	}
+	if c.MainDB == c.DailyDB {
+		return errors.New("main-db and daily-db must differ")
+	}
+	if c.BackupKeep < 1 {
+		return errors.New("backup-keep must be at least 1")
+	}
+	if c.FetchDelaySec < 0 {
+		return errors.New("fetch-delay must not be negative")
+	}
+	if c.FetchTimeoutSec <= 0 || c.FetchRetries < 0 {
+		return errors.New("fetch-timeout must be positive and fetch-retries not negative")
+	}
+	if c.FetchDays < 1 || c.FetchAttempts < 1 {
+		return errors.New("fetch-days and fetch-attempts must be positive")
+	}
+	if _, err := time.Parse(FETCH_TIME_FORMAT, c.FetchTime); err != nil {
+		return fmt.Errorf("fetch-time %q is not HH:MM", c.FetchTime)
+	}
+	if err := validateURLFormat("twse-url", c.TWSEURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("tpex-url", c.TPExURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("twse-stock-url", c.TWSEStockURL, "year, month and code", 2000, 1, "0050"); err != nil {
+		return err
+	}
+	if err := validateURLFormat("tpex-stock-url", c.TPExStockURL, "year, month and code", 2000, 1, "0050"); err != nil {
+		return err
+	}
+	if err := validateURLFormat("twse-exright-url", c.TWSEExRightURL, "year, month and day of start and end", 2000, 1, 1, 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("tpex-exright-url", c.TPExExRightURL, "year, month and day of start and end", 2000, 1, 1, 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("twse-inst-url", c.TWSEInstURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("tpex-inst-url", c.TPExInstURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("twse-margin-url", c.TWSEMarginURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("tpex-margin-url", c.TPExMarginURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("twse-value-url", c.TWSEValueURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("tpex-value-url", c.TPExValueURL, "year, month and day", 2000, 1, 1); err != nil {
+		return err
+	}
+	if err := validateURLFormat("twse-revenue-url", c.TWSERevenueURL, "nothing"); err != nil {
+		return err
+	}
+	if err := validateURLFormat("tpex-revenue-url", c.TPExRevenueURL, "nothing"); err != nil {
+		return err
+	}
+	if c.ValuationYears < 1 {
+		return errors.New("valuation-years must be positive")
+	}
+	if c.BackfillMonths < 1 {
+		return errors.New("backfill-months must be positive")
+	}
+	// genMA needs a full window of leading days for MA20.
+	if c.BaseQDsNr < 20 {
+		return errors.New("base-days must be at least 20")
+	}
+	if c.ShowingQDs < c.BaseQDsNr {
+		return errors.New("showing-days must not be less than base-days")
+	}
+	if c.MinInterestedVol < 0 || c.MaxTransmitSize < 1 {
+		return errors.New("min-volume must not be negative and max-results must be positive")
+	}
+	return nil
+}
+
+// Print writes c in the config file format.
+func (c Config) Print() {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(c)
+}
@@ -0,0 +1,213 @@
+package myDatabase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const MAIN_DB_FILE = "transactionDB.sqlite"
+const DAILY_DB_FILE = "dailyDB.sqlite"
+
+var ErrNoSuchSnapshot error = errors.New("no such snapshot")
+
+var snapshotPattern = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}(\.[0-9]{3})?$`)
+
+// A snapshot is a directory under the backup directory named by its creation time,
+// holding one consistent copy of each database.
+type Snapshot struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Write a consistent copy of conn to path. VACUUM INTO reads inside one
+// transaction, so it is safe while the server keeps writing.
+func vacuumInto(conn *sql.DB, path string) error {
+	_, err := conn.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// BackupMyDB takes a snapshot of both databases and drops the oldest ones
+// beyond the configured count.
+func BackupMyDB() (snap Snapshot, err error) {
+	snap, err = takeSnapshot()
+	if err != nil {
+		return snap, err
+	}
+	return snap, rotateSnapshots(config.BackupKeep)
+}
+
+// Named by the time to the millisecond, so a restore, which snapshots the
+// live databases first, can follow a backup right away.
+func newSnapshotDir() (name string, dir string, err error) {
+	name = time.Now().Format("20060102-150405.000")
+	dir = filepath.Join(config.BackupDir, name)
+	if _, err = os.Stat(dir); err == nil {
+		return name, dir, fmt.Errorf("snapshot %s already exists", name)
+	}
+	return name, dir, os.MkdirAll(dir, 0700)
+}
+
+func takeSnapshot() (snap Snapshot, err error) {
+	name, dir, err := newSnapshotDir()
+	if err != nil {
+		return snap, err
+	}
+	snap.Name = name
+
+	err = vacuumInto(db, filepath.Join(dir, MAIN_DB_FILE))
+	if err == nil {
+		err = vacuumInto(scanDB, filepath.Join(dir, DAILY_DB_FILE))
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return snap, err
+	}
+	snap.Size = dirSize(dir)
+	return snap, nil
+}
+
+func dirSize(dir string) (size int64) {
+	entries, _ := os.ReadDir(dir)
+	for _, ent := range entries {
+		if info, err := ent.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// ListSnapshots returns snapshots, oldest first.
+func ListSnapshots() ([]Snapshot, error) {
+	entries, err := os.ReadDir(config.BackupDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list := []Snapshot{}
+	for _, ent := range entries {
+		if !ent.IsDir() || !snapshotPattern.MatchString(ent.Name()) {
+			continue
+		}
+		size := dirSize(filepath.Join(config.BackupDir, ent.Name()))
+		list = append(list, Snapshot{Name: ent.Name(), Size: size})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func rotateSnapshots(keep int) error {
+	list, err := ListSnapshots()
+	if err != nil {
+		return err
+	}
+	for len(list) > keep {
+		fmt.Println("Removing old snapshot", list[0].Name)
+		if err = os.RemoveAll(filepath.Join(config.BackupDir, list[0].Name)); err != nil {
+			return err
+		}
+		list = list[1:]
+	}
+	return nil
+}
+
+// Copy every page of src into dst with the SQLite online backup API. Other
+// connections to dst see either the old or the new content, never a mix.
+func copyDB(dst *sql.DB, src *sql.DB) error {
+	ctx := context.Background()
+	dconn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dconn.Close()
+	sconn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sconn.Close()
+
+	return dconn.Raw(func(d any) error {
+		return sconn.Raw(func(s any) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			_, err = b.Step(-1)
+			if err != nil {
+				b.Close()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+func restoreFile(dst *sql.DB, path string) error {
+	src, err := sql.Open("sqlite3", readOnlyDSN(path))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return copyDB(dst, src)
+}
+
+// Copy the databases in dir over the live ones and bring them to the current
+// schema, since the snapshot may predate it.
+func restoreSnapshot(dir string) error {
+	err := restoreFile(db, filepath.Join(dir, MAIN_DB_FILE))
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(filepath.Join(dir, DAILY_DB_FILE)); err == nil {
+		err = restoreFile(scanDB, filepath.Join(dir, DAILY_DB_FILE))
+		if err != nil {
+			return err
+		}
+	}
+	if err = migrate(db, MAIN_DB_NAME, mainMigrations); err != nil {
+		return err
+	}
+	return migrate(scanDB, DAILY_DB_NAME, dailyMigrations)
+}
+
+// RestoreMyDB replaces the live databases by the named snapshot. A database
+// missing from the snapshot, like the daily one in an imported export, is
+// left as it is. The current state is saved as a new snapshot first, so a
+// restore can be undone, and is put back if the restore fails midway.
+func RestoreMyDB(name string) (saved Snapshot, err error) {
+	if !snapshotPattern.MatchString(name) {
+		return saved, ErrNoSuchSnapshot
+	}
+	dir := filepath.Join(config.BackupDir, name)
+	if _, err = os.Stat(filepath.Join(dir, MAIN_DB_FILE)); err != nil {
+		return saved, ErrNoSuchSnapshot
+	}
+
+	saved, err = takeSnapshot()
+	if err != nil {
+		return saved, err
+	}
+
+	err = restoreSnapshot(dir)
+	if err != nil {
+		// Put the saved state back whole, so the ledger and the quotes never
+		// come from different snapshots.
+		if rerr := restoreSnapshot(filepath.Join(config.BackupDir, saved.Name)); rerr != nil {
+			err = fmt.Errorf("%w; undoing it failed too: %v", err, rerr)
+		}
+		invalidateCalendar()
+		return saved, fmt.Errorf("restore %s: %w", name, err)
+	}
+	invalidateCalendar()
+	return saved, rotateSnapshots(config.BackupKeep)
+}
@@ -0,0 +1,223 @@
+package myDatabase
+
+import (
+	"sync"
+	"time"
+)
+
+const CALENDAR_TABLE string = "calendar"
+
+// Where a calendar day came from.
+const CAL_SOURCE_FILE = "file"
+const CAL_SOURCE_LEARNED = "learned"
+
+// CalendarDay overrides the weekday rule for one date: a holiday or closure
+// on a weekday, or a make-up trading Saturday.
+type CalendarDay struct {
+	Date   Date   `json:"date"`
+	Open   bool   `json:"open"`
+	Source string `json:"source,omitempty"`
+	Note   string `json:"note,omitempty"`
+}
+
+// Holidays on the same date every year. Lunar holidays, observed days and
+// typhoon closures come from the calendar file or are learned from data.
+var fixedHolidays = []struct {
+	month time.Month
+	day   int
+}{
+	{time.January, 1},
+	{time.February, 28},
+	{time.May, 1},
+	{time.October, 10},
+}
+
+// The calendar table is small and read on every date step, so it is cached.
+var calMu sync.RWMutex
+var calDays map[string]CalendarDay
+
+func loadCalendar() (map[string]CalendarDay, error) {
+	calMu.RLock()
+	days := calDays
+	calMu.RUnlock()
+	if days != nil {
+		return days, nil
+	}
+
+	rows, err := scanDB.Query("SELECT date, open, source, note FROM " + CALENDAR_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	days = map[string]CalendarDay{}
+	for rows.Next() {
+		var c CalendarDay
+		if err = rows.Scan(&c.Date, &c.Open, &c.Source, &c.Note); err != nil {
+			return nil, err
+		}
+		days[c.Date.String()] = c
+	}
+
+	calMu.Lock()
+	calDays = days
+	calMu.Unlock()
+	return days, nil
+}
+
+func invalidateCalendar() {
+	calMu.Lock()
+	calDays = nil
+	calMu.Unlock()
+}
+
+// SetCalendarDays stores days, replacing earlier entries of the same dates.
+func SetCalendarDays(days []CalendarDay) error {
+	defer invalidateCalendar()
+	cmd := "INSERT OR REPLACE INTO " + CALENDAR_TABLE +
+		" (date, open, source, note) VALUES (?, ?, ?, ?)"
+	for _, c := range days {
+		if c.Source == "" {
+			c.Source = CAL_SOURCE_FILE
+		}
+		if _, err := scanDB.Exec(cmd, c.Date, c.Open, c.Source, c.Note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LearnClosed marks d closed, unless the calendar already says otherwise.
+func LearnClosed(d Date, note string) error {
+	defer invalidateCalendar()
+	cmd := "INSERT OR IGNORE INTO " + CALENDAR_TABLE +
+		" (date, open, source, note) VALUES (?, 0, ?, ?)"
+	_, err := scanDB.Exec(cmd, d, CAL_SOURCE_LEARNED, note)
+	return err
+}
+
+// LearnFromQuotes fills the calendar in from the dates in the quotes table.
+// Every listed stock has a row on each trading day, so within the stretch of
+// market-wide days a weekday without rows was closed and a weekend day with
+// rows was a make-up day. Market-wide days have at least half the most rows
+// of a day; days outside them may hold only backfilled stocks and are left
+// alone, as are entries the calendar already has. Returns the number of
+// days learned.
+func LearnFromQuotes() (learned int, err error) {
+	defer invalidateCalendar()
+	rows, err := scanDB.Query("SELECT date, COUNT(*) FROM " + QUOTE_TABLE + " GROUP BY date ORDER BY date")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	type dayCount struct {
+		date Date
+		nr   int
+	}
+	list := []dayCount{}
+	most := 0
+	for rows.Next() {
+		var c dayCount
+		if err = rows.Scan(&c.date, &c.nr); err != nil {
+			return 0, err
+		}
+		list = append(list, c)
+		most = max(most, c.nr)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	traded := map[string]bool{}
+	var from, to Date
+	for _, c := range list {
+		traded[c.date.String()] = true
+		if 2*c.nr < most {
+			continue
+		}
+		if from.IsZero() {
+			from = c.date
+		}
+		to = c.date
+	}
+	if from.IsZero() {
+		return 0, nil
+	}
+
+	days, err := loadCalendar()
+	if err != nil {
+		return 0, err
+	}
+	cmd := "INSERT OR IGNORE INTO " + CALENDAR_TABLE +
+		" (date, open, source, note) VALUES (?, ?, ?, ?)"
+	for d := from; !d.After(to); d = d.AddDays(1) {
+		open := traded[d.String()]
+		if open == isTradingDay(days, d) {
+			continue
+		}
+		res, err := scanDB.Exec(cmd, d, open, CAL_SOURCE_LEARNED, "from quotes")
+		if err != nil {
+			return learned, err
+		}
+		n, _ := res.RowsAffected()
+		learned += int(n)
+	}
+	return learned, nil
+}
+
+func isTradingDay(days map[string]CalendarDay, d Date) bool {
+	if c, exist := days[d.String()]; exist {
+		return c.Open
+	}
+	switch d.Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	_, m, day := d.Date()
+	for _, h := range fixedHolidays {
+		if m == h.month && day == h.day {
+			return false
+		}
+	}
+	return true
+}
+
+// IsTradingDay tells whether the exchanges trade on d. Without a readable
+// calendar it falls back to the weekday and fixed-holiday rules.
+func IsTradingDay(d Date) bool {
+	days, _ := loadCalendar()
+	return isTradingDay(days, d)
+}
+
+// NextTradingDay returns the first trading day after d.
+func NextTradingDay(d Date) Date {
+	days, _ := loadCalendar()
+	for {
+		d = d.AddDays(1)
+		if isTradingDay(days, d) {
+			return d
+		}
+	}
+}
+
+// PrevTradingDay returns the last trading day before d.
+func PrevTradingDay(d Date) Date {
+	days, _ := loadCalendar()
+	for {
+		d = d.AddDays(-1)
+		if isTradingDay(days, d) {
+			return d
+		}
+	}
+}
+
+// TradingDaysBetween lists the trading days from from to to, both included.
+func TradingDaysBetween(from Date, to Date) []Date {
+	days, _ := loadCalendar()
+	list := []Date{}
+	for d := from; !d.After(to); d = d.AddDays(1) {
+		if isTradingDay(days, d) {
+			list = append(list, d)
+		}
+	}
+	return list
+}
@@ -4,69 +4,56 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"slices"
-	"strconv"
-	"strings"
-	"time"
 )
 
+// Stocks are still named stk<code>, after the per-stock tables of the
+// daily schema before version 11.
 const STKPREFIX string = "stk"
+
+// Quotes of every stock, keyed by (code, date).
+const QUOTE_TABLE string = "quotes"
+
+// Dropped in daily schema version 4 for the fetch log.
 const CHECKED_DATE_TABLE string = "checkdate"
 
 var ErrNoSuchTable error = errors.New("new stock")
 
-type DaliyQuote struct {
-	Year   int     `json:"year"`
-	Month  int     `json:"month"`
-	Day    int     `json:"day"`
-	Volume int64   `json:"volume"` // 成交股數
-	Trans  int     `json:"trans"`  // 交易筆數
-	Value  int64   `json:"value"`  // 交易金額
-	Open   float64 `json:"open"`
-	High   float64 `json:"high"`
-	Low    float64 `json:"low"`
-	Close  float64 `json:"close"`
-}
+const DQ_COLUMNS = "id, date, volume, trans, value, open, high, low, close"
 
-func initStockTbl() {
-	cmd := `CREATE TABLE IF NOT EXISTS ` + CHECKED_DATE_TABLE + ` (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		startdate TEXT NOT NULL
-	    )`
+// Columns a quote is stored in, after code.
+const DQ_STORE_COLUMNS = "date, volume, trans, value, open, high, low, close"
 
-	if _, err := scanDB.Exec(cmd); err != nil {
-		log.Fatalf("DQ: Failed to create checked-table: %v", err)
-	}
+type DaliyQuote struct {
+	Date   Date  `json:"date"`
+	Volume int64 `json:"volume"` // 成交股數
+	Trans  int   `json:"trans"`  // 交易筆數
+	Value  int64 `json:"value"`  // 交易金額
+	Open   Price `json:"open"`
+	High   Price `json:"high"`
+	Low    Price `json:"low"`
+	Close  Price `json:"close"`
 }
 
-func checkStockTbl(code string) error {
-	cmd := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s%s (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		year INTEGER NOT NULL,
-		month INTEGER NOT NULL,
-		day INTEGER NOT NULL,
-		volume INTEGER NOT NULL,
-		trans INTEGER NOT NULL,
-		value INTEGER NOT NULL,
-		open REAL NOT NULL,
-		high REAL NOT NULL,
-		low REAL NOT NULL,
-		close REAL NOT NULL
-	    )`, STKPREFIX, code)
-
-	if _, err := scanDB.Exec(cmd); err != nil {
-		log.Fatalf("DQ: Failed to create StockTbl: %v\n%s", err, cmd)
-		return err
+func genDailyQuote(rows *sql.Rows) (dq []DaliyQuote, err error) {
+	for rows.Next() {
+		var r DaliyQuote
+		var Id int
+		err := rows.Scan(&Id, &r.Date, &r.Volume, &r.Trans, &r.Value, &r.Open, &r.High, &r.Low, &r.Close)
+		if err != nil {
+			return dq, err
+		}
+		dq = append(dq, r)
 	}
-	return nil
+	return dq, nil
 }
 
-func GetTableList() []string {
+// Per-stock tables of the daily schema before version 11, for the migrations.
+func stockTables(q querier) ([]string, error) {
 	cmd := "SELECT name FROM sqlite_master WHERE type='table'"
-	rows, err := scanDB.Query(cmd)
+	rows, err := q.Query(cmd)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -75,119 +62,184 @@ func GetTableList() []string {
 		var str string
 		err := rows.Scan(&str)
 		if err != nil {
-			return nil
+			return nil, err
 		}
-		if strings.HasPrefix(str, "stk") {
+		if checkStockTblName(str) == nil {
 			tblList = append(tblList, str)
 		}
 	}
-	return tblList
+	return tblList, nil
 }
 
-func GetDQCheckedDate() time.Time {
-	var st string
-	var s time.Time
-	cmd := fmt.Sprintf("SELECT startdate FROM " + CHECKED_DATE_TABLE)
-	row := scanDB.QueryRow(cmd)
-	err := row.Scan(&st)
-	if err != nil {
-		fmt.Println("GetChecked table failed.")
-		goto errOut
-	}
+// StockQuote is the quote of one stock on one day.
+type StockQuote struct {
+	Code string `json:"code"`
+	DaliyQuote
+}
 
-	s, err = time.Parse("20060102", st)
+// Stock names, stk<code>, of every stock with a quote, by code.
+func GetTableList() []string {
+	rows, err := scanDB.Query("SELECT DISTINCT code FROM " + QUOTE_TABLE + " ORDER BY code")
 	if err != nil {
-		fmt.Println("GetChecked parse start failed.")
-		goto errOut
+		return nil
 	}
-	return s
+	defer rows.Close()
 
-errOut:
-	return time.Now().AddDate(0, 0, -70)
+	tblList := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil
+		}
+		tblList = append(tblList, STKPREFIX+code)
+	}
+	return tblList
 }
 
-func SetDQCheckedDate(s time.Time) error {
-	cmd := fmt.Sprintf("UPDATE %s SET startdate = '%s'", CHECKED_DATE_TABLE, s.Format("20060102"))
-	_, err := scanDB.Exec(cmd)
-	return err
-}
+// GetDailyQuote returns the last days quotes of the stock named tblName,
+// oldest first. ErrNoSuchTable means the stock has no quote yet.
+func GetDailyQuote(tblName string, days int) (dq []DaliyQuote, err error) {
+	code, err := stockCode(tblName)
+	if err != nil {
+		return dq, err
+	}
+	cmd := "SELECT " + DQ_COLUMNS + " FROM " + QUOTE_TABLE +
+		" WHERE code = ?" +
+		" ORDER BY date DESC" +
+		" LIMIT ?"
+	rows, err := scanDB.Query(cmd, code, days)
+	if err != nil {
+		fmt.Printf("Failed to query DQ (%s) err=%s\n", cmd, err.Error())
+		return dq, err
+	}
+	defer rows.Close()
 
-func checkDailyQuoteExist(code string, y int, m int, d int) (bool, error) {
-	var id int
-	cmd := fmt.Sprintf("SELECT id FROM "+STKPREFIX+code+
-		" WHERE year = %d AND month = %d AND day = %d", y, m, d)
-	row := scanDB.QueryRow(cmd)
-	err := row.Scan(&id)
+	dq, err = genDailyQuote(rows)
+	if err == nil && len(dq) == 0 && days > 0 {
+		return dq, ErrNoSuchTable
+	}
+	slices.Reverse(dq)
+	return dq, err
+}
 
-	if err == sql.ErrNoRows {
-		return false, nil
-	} else if err != nil {
-		return false, err
-	} else {
-		return true, nil
+// GetDailyQuoteRange returns quotes of code within r, oldest first.
+func GetDailyQuoteRange(code string, r DateRange) ([]DaliyQuote, error) {
+	if err := ValidateCode(code); err != nil {
+		return nil, err
 	}
+	return queryRange(scanDB, genDailyQuote, QUOTE_TABLE, DQ_COLUMNS, r, "code = ?", code)
 }
 
-func GetDailyQuote(tblName string, days int) (dq []DaliyQuote, err error) {
-	cmd := "SELECT * FROM " + tblName +
-		" ORDER BY year DESC, month DESC, day DESC" +
-		" LIMIT " + strconv.Itoa(days)
-	rows, err := scanDB.Query(cmd)
+// GetQuotesOn returns the quote of every stock traded on d, by code.
+func GetQuotesOn(d Date) (list []StockQuote, err error) {
+	cmd := "SELECT code, " + DQ_COLUMNS + " FROM " + QUOTE_TABLE +
+		" WHERE date = ?" +
+		" ORDER BY code"
+	rows, err := scanDB.Query(cmd, d)
 	if err != nil {
-		fmt.Printf("Failed to query DQ (%s) err=%s\n", cmd, err.Error())
-		return dq, ErrNoSuchTable
+		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var r DaliyQuote
+		var q StockQuote
 		var Id int
-		err := rows.Scan(&Id, &r.Year, &r.Month, &r.Day, &r.Volume, &r.Trans, &r.Value, &r.Open, &r.High, &r.Low, &r.Close)
+		err = rows.Scan(&q.Code, &Id, &q.Date, &q.Volume, &q.Trans, &q.Value, &q.Open, &q.High, &q.Low, &q.Close)
 		if err != nil {
-			return dq, err
+			return list, err
 		}
-		dq = append(dq, r)
+		list = append(list, q)
 	}
-	slices.Reverse(dq)
-	return dq, err
+	return list, nil
+}
+
+// FindPrevDailyQuote returns the last quote of code dated before d, or a zero
+// quote if there is none.
+func FindPrevDailyQuote(code string, d Date) (dq DaliyQuote, err error) {
+	if err = ValidateCode(code); err != nil {
+		return dq, err
+	}
+	return findPrevDailyQuote(scanDB, code, d)
 }
 
-func FindPrevDailyQuote(code string, y int, m int, d int) (dq DaliyQuote, err error) {
-	cmd := fmt.Sprintf("SELECT * FROM %s%s"+
-		" WHERE year < %d OR"+
-		"       year = %d AND month < %d OR"+
-		"       year = %d AND month = %d AND day < %d"+
-		" ORDER BY year DESC, month DESC, day DESC"+
-		" LIMIT 1", STKPREFIX, code, y, y, m, y, m, d)
-	row := scanDB.QueryRow(cmd)
+func findPrevDailyQuote(q querier, code string, d Date) (dq DaliyQuote, err error) {
+	cmd := "SELECT " + DQ_COLUMNS + " FROM " + QUOTE_TABLE +
+		" WHERE code = ? AND date < ?" +
+		" ORDER BY date DESC" +
+		" LIMIT 1"
+	row := q.QueryRow(cmd, code, d)
 	var Id int
-	err = row.Scan(&Id, &dq.Year, &dq.Month, &dq.Day, &dq.Volume, &dq.Trans, &dq.Value, &dq.Open, &dq.High, &dq.Low, &dq.Close)
-	if err != nil {
-		fmt.Println("Failed to query prev DQ", cmd)
+	err = row.Scan(&Id, &dq.Date, &dq.Volume, &dq.Trans, &dq.Value, &dq.Open, &dq.High, &dq.Low, &dq.Close)
+	if err == sql.ErrNoRows {
 		return dq, nil
+	} else if err != nil {
+		fmt.Println("Failed to query prev DQ", cmd)
+		return dq, err
 	}
-	return dq, err
+	return dq, nil
+}
+
+// Insert of one quote. A stored quote of the same code and day is replaced
+// if overwrite is set and kept otherwise.
+func quoteInsertCmd(overwrite bool) string {
+	cmd := "INSERT INTO " + QUOTE_TABLE +
+		" (code, " + DQ_STORE_COLUMNS + ")" +
+		" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	if !overwrite {
+		return cmd + " ON CONFLICT (code, date) DO NOTHING"
+	}
+	return cmd + " ON CONFLICT (code, date) DO UPDATE SET" +
+		" volume = excluded.volume, trans = excluded.trans, value = excluded.value," +
+		" open = excluded.open, high = excluded.high, low = excluded.low, close = excluded.close"
 }
 
+// AddDailyQuote stores dq unless code already has a quote that day.
 func AddDailyQuote(code string, dq *DaliyQuote) error {
-	err := checkStockTbl(code)
-	if err != nil {
+	if err := ValidateCode(code); err != nil {
 		return err
 	}
+	_, err := scanDB.Exec(quoteInsertCmd(false), code, dq.Date, dq.Volume, dq.Trans, dq.Value, dq.Open, dq.High, dq.Low, dq.Close)
+	return err
+}
 
-	exist, err := checkDailyQuoteExist(code, dq.Year, dq.Month, dq.Day)
+// PutDailyQuotes stores list, such as the quotes of one market on one day, in
+// one transaction: on error nothing of it is stored. Stored quotes of the same
+// code and day are replaced if overwrite is set and kept otherwise. A quote
+// without trades takes the last close before its own date as its prices,
+// which stays right when days are loaded out of order. Returns the number of
+// quotes written.
+func PutDailyQuotes(list []StockQuote, overwrite bool) (stored int, err error) {
+	for _, q := range list {
+		if err := ValidateCode(q.Code); err != nil {
+			return 0, err
+		}
+	}
+	tx, err := scanDB.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if exist {
-		return nil
+	stmt, err := tx.Prepare(quoteInsertCmd(overwrite))
+	if err != nil {
+		tx.Rollback()
+		return 0, err
 	}
-
-	tblName := STKPREFIX + code
-	cmd := "INSERT INTO " + tblName +
-		" (year, month, day, volume, trans, value, open, high, low, close)" +
-		" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
-
-	_, err = scanDB.Exec(cmd, dq.Year, dq.Month, dq.Day, dq.Volume, dq.Trans, dq.Value, dq.Open, dq.High, dq.Low, dq.Close)
-	return err
+	defer stmt.Close()
+	for _, q := range list {
+		if q.Volume == 0 {
+			prev, err := findPrevDailyQuote(tx, q.Code, q.Date)
+			if err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+			q.Open, q.High, q.Low, q.Close = prev.Close, prev.Close, prev.Close, prev.Close
+		}
+		res, err := stmt.Exec(q.Code, q.Date, q.Volume, q.Trans, q.Value, q.Open, q.High, q.Low, q.Close)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("quote of %s on %s: %w", q.Code, q.Date, err)
+		}
+		n, _ := res.RowsAffected()
+		stored += int(n)
+	}
+	return stored, tx.Commit()
 }
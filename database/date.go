@@ -0,0 +1,178 @@
+package myDatabase
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const DATE_FORMAT = "2006-01-02"
+
+// Date is a calendar day. It is stored as 'YYYY-MM-DD' text, so the column
+// sorts and compares in date order and can be indexed.
+type Date struct {
+	time.Time
+}
+
+func NewDate(y int, m int, d int) Date {
+	return Date{time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)}
+}
+
+// DateOf takes the calendar day of t in its own location.
+func DateOf(t time.Time) Date {
+	y, m, d := t.Date()
+	return NewDate(y, int(m), d)
+}
+
+func Today() Date {
+	return DateOf(time.Now())
+}
+
+// ParseDate accepts both YYYY-MM-DD and YYYYMMDD.
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(DATE_FORMAT, s)
+	if err != nil {
+		t, err = time.Parse("20060102", s)
+		if err != nil {
+			return Date{}, fmt.Errorf("invalid date %q", s)
+		}
+	}
+	return Date{t}, nil
+}
+
+func (d Date) String() string {
+	return d.Format(DATE_FORMAT)
+}
+
+func (d Date) AddDays(n int) Date {
+	return Date{d.AddDate(0, 0, n)}
+}
+
+func (d Date) Before(o Date) bool {
+	return d.Time.Before(o.Time)
+}
+
+func (d Date) After(o Date) bool {
+	return d.Time.After(o.Time)
+}
+
+func (d Date) Equal(o Date) bool {
+	return d.Time.Equal(o.Time)
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Date) Scan(src any) (err error) {
+	switch v := src.(type) {
+	case string:
+		*d, err = ParseDate(v)
+	case []byte:
+		*d, err = ParseDate(string(v))
+	case time.Time:
+		*d = DateOf(v)
+	default:
+		err = fmt.Errorf("cannot scan %T into Date", src)
+	}
+	return err
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*d, err = ParseDate(s)
+	return err
+}
+
+// DateRange is an inclusive range of days. A zero From or To leaves that
+// side open.
+type DateRange struct {
+	From Date
+	To   Date
+}
+
+// Predicate on the date column, to be used after WHERE.
+func (r DateRange) where() (string, []any) {
+	switch {
+	case r.From.IsZero() && r.To.IsZero():
+		return "1", nil
+	case r.From.IsZero():
+		return "date <= ?", []any{r.To}
+	case r.To.IsZero():
+		return "date >= ?", []any{r.From}
+	}
+	return "date BETWEEN ? AND ?", []any{r.From, r.To}
+}
+
+// Select rows of table within r in date order, ties broken by id. filter is
+// an optional extra predicate whose arguments come in args. columns are
+// listed in the order scan reads them.
+func queryRange[T any](q querier, scan func(*sql.Rows) ([]T, error),
+	table string, columns string, r DateRange, filter string, args ...any) ([]T, error) {
+
+	cond, rargs := r.where()
+	if filter != "" {
+		cond = filter + " AND " + cond
+	}
+	cmd := "SELECT " + columns + " FROM " + table +
+		" WHERE " + cond +
+		" ORDER BY date, id"
+	rows, err := q.Query(cmd, append(args, rargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scan(rows)
+}
+
+// Insert into table that replaces the stored row of the same keys.
+func upsertCmd(table string, keys []string, columns ...string) string {
+	all := append(append([]string{}, keys...), columns...)
+	set := make([]string, len(columns))
+	for i, c := range columns {
+		set[i] = c + " = excluded." + c
+	}
+	return "INSERT INTO " + table + " (" + strings.Join(all, ", ") + ")" +
+		" VALUES (" + strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", ") + ")" +
+		" ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// Store list with cmd in one transaction, the arguments of each row given by
+// args; on error nothing is stored. If code is given, every row must carry a
+// valid stock code.
+func putRows[T any](cmd string, list []T, code func(T) string, args func(T) []any) error {
+	if code != nil {
+		for _, row := range list {
+			if err := ValidateCode(code(row)); err != nil {
+				return err
+			}
+		}
+	}
+	tx, err := scanDB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(cmd)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range list {
+		if _, err = stmt.Exec(args(row)...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,156 @@
+package myDatabase
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Export file layout: magic | salt | nonce | AES-256-GCM(tar.gz of the ledger).
+const EXPORT_MAGIC = "MYSTOCK-EXPORT1\n"
+const EXPORT_SALT_LEN = 16
+const EXPORT_KDF_ITER = 600000
+
+var ErrBadPassphrase error = errors.New("wrong passphrase or corrupted export")
+var ErrNotExport error = errors.New("not an export file")
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, EXPORT_KDF_ITER, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ExportLedger writes a passphrase-encrypted archive of the transaction
+// database to w.
+func ExportLedger(w io.Writer, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("empty passphrase")
+	}
+
+	tmp, err := os.MkdirTemp("", "mystock-export")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, MAIN_DB_FILE)
+	if err = vacuumInto(db, path); err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var archive bytes.Buffer
+	zw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(zw)
+	hdr := &tar.Header{Name: MAIN_DB_FILE, Mode: 0600, Size: int64(len(content)), ModTime: time.Now()}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err = tw.Write(content); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	salt := make([]byte, EXPORT_SALT_LEN)
+	if _, err = rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+
+	out := append([]byte(EXPORT_MAGIC), salt...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, archive.Bytes(), []byte(EXPORT_MAGIC))
+	_, err = w.Write(out)
+	return err
+}
+
+// ImportLedger decrypts an export into a new snapshot, which RestoreMyDB can
+// then bring live.
+func ImportLedger(r io.Reader, passphrase string) (snap Snapshot, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return snap, err
+	}
+	if !bytes.HasPrefix(data, []byte(EXPORT_MAGIC)) {
+		return snap, ErrNotExport
+	}
+	data = data[len(EXPORT_MAGIC):]
+	if len(data) < EXPORT_SALT_LEN {
+		return snap, ErrNotExport
+	}
+	salt, data := data[:EXPORT_SALT_LEN], data[EXPORT_SALT_LEN:]
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return snap, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return snap, ErrNotExport
+	}
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, data, []byte(EXPORT_MAGIC))
+	if err != nil {
+		return snap, ErrBadPassphrase
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return snap, err
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return snap, err
+	}
+	if hdr.Name != MAIN_DB_FILE {
+		return snap, ErrNotExport
+	}
+
+	name, dir, err := newSnapshotDir()
+	if err != nil {
+		return snap, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, MAIN_DB_FILE), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return snap, err
+	}
+
+	snap.Name = name
+	snap.Size = dirSize(dir)
+	return snap, nil
+}
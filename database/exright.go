@@ -0,0 +1,96 @@
+package myDatabase
+
+import (
+	"database/sql"
+)
+
+const EXRIGHT_TABLE string = "exright"
+
+const EXRIGHT_COLUMNS = "id, date, code, kind, prev_close, ref_price"
+
+// ExRight is one ex-rights or ex-dividend event: on Date, code opened
+// against RefPrice instead of the PrevClose of the day before.
+type ExRight struct {
+	Date      Date   `json:"date"`
+	Code      string `json:"code"`
+	Kind      string `json:"kind"` // 權, 息 or 權息
+	PrevClose Price  `json:"prevClose"`
+	RefPrice  Price  `json:"refPrice"`
+}
+
+// Whether the event moved the reference price, so earlier prices need scaling.
+func (e ExRight) adjusts() bool {
+	return e.PrevClose > 0 && e.RefPrice > 0 && e.PrevClose != e.RefPrice
+}
+
+// Scale p, a price before the event, to be comparable with prices after.
+func (e ExRight) adjust(p Price) Price {
+	return p.MulRatio(int64(e.RefPrice), int64(e.PrevClose))
+}
+
+func genExRight(rows *sql.Rows) (list []ExRight, err error) {
+	for rows.Next() {
+		var e ExRight
+		var Id int
+		err := rows.Scan(&Id, &e.Date, &e.Code, &e.Kind, &e.PrevClose, &e.RefPrice)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// PutExRights stores events, replacing stored ones of the same date and code.
+func PutExRights(list []ExRight) error {
+	cmd := upsertCmd(EXRIGHT_TABLE, []string{"date", "code"}, "kind", "prev_close", "ref_price")
+	return putRows(cmd, list, func(e ExRight) string { return e.Code }, func(e ExRight) []any {
+		return []any{e.Date, e.Code, e.Kind, e.PrevClose, e.RefPrice}
+	})
+}
+
+// GetExRights returns the events of code within r, oldest first.
+func GetExRights(code string, r DateRange) ([]ExRight, error) {
+	if err := ValidateCode(code); err != nil {
+		return nil, err
+	}
+	return queryRange(scanDB, genExRight, EXRIGHT_TABLE, EXRIGHT_COLUMNS, r, "code = ?", code)
+}
+
+// AdjustQuotes back-adjusts dqs, oldest first, for events: prices before
+// each event are scaled by its factor so the latest prices stay as traded.
+// Volumes are left raw.
+func AdjustQuotes(dqs []DaliyQuote, events []ExRight) []DaliyQuote {
+	adjusted := make([]DaliyQuote, len(dqs))
+	copy(adjusted, dqs)
+	for _, e := range events {
+		if !e.adjusts() {
+			continue
+		}
+		for i := range adjusted {
+			if !adjusted[i].Date.Before(e.Date) {
+				break
+			}
+			adjusted[i].Open = e.adjust(adjusted[i].Open)
+			adjusted[i].High = e.adjust(adjusted[i].High)
+			adjusted[i].Low = e.adjust(adjusted[i].Low)
+			adjusted[i].Close = e.adjust(adjusted[i].Close)
+		}
+	}
+	return adjusted
+}
+
+// GetAdjustedDailyQuote is GetDailyQuote with back-adjusted prices.
+func GetAdjustedDailyQuote(tblName string, days int) ([]DaliyQuote, error) {
+	dqs, err := GetDailyQuote(tblName, days)
+	if err != nil || len(dqs) == 0 {
+		return dqs, err
+	}
+	// After the prefix check of GetDailyQuote.
+	code := tblName[len(STKPREFIX):]
+	events, err := GetExRights(code, DateRange{From: dqs[0].Date.AddDays(1)})
+	if err != nil {
+		return dqs, err
+	}
+	return AdjustQuotes(dqs, events), nil
+}
@@ -0,0 +1,72 @@
+package myDatabase
+
+import (
+	"database/sql"
+	"time"
+)
+
+const FETCH_LOG_TABLE string = "fetchlog"
+
+const FETCH_LOG_COLUMNS = "id, date, market, status, rows, error, attempts, updated"
+
+// Fetch outcome of one (date, market) pair.
+const FETCH_OK = "ok"
+const FETCH_FAILED = "failed"
+const FETCH_CLOSED = "closed"
+
+type FetchLog struct {
+	Date     Date      `json:"date"`
+	Market   string    `json:"market"`
+	Status   string    `json:"status"`
+	Rows     int       `json:"rows"`
+	Error    string    `json:"error,omitempty"`
+	Attempts int       `json:"attempts"`
+	Updated  time.Time `json:"updated"`
+}
+
+func genFetchLog(rows *sql.Rows) (logs []FetchLog, err error) {
+	for rows.Next() {
+		var l FetchLog
+		var Id int
+		var updated string
+		err := rows.Scan(&Id, &l.Date, &l.Market, &l.Status, &l.Rows, &l.Error, &l.Attempts, &updated)
+		if err != nil {
+			return logs, err
+		}
+		l.Updated, _ = time.Parse(time.RFC3339, updated)
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+// GetFetchLogRange returns fetch records within r, oldest first.
+func GetFetchLogRange(r DateRange) ([]FetchLog, error) {
+	return queryRange(scanDB, genFetchLog, FETCH_LOG_TABLE, FETCH_LOG_COLUMNS, r, "")
+}
+
+// RecordFetch stores the outcome of one fetch attempt of (d, market).
+func RecordFetch(d Date, market string, status string, rowNr int, fetchErr error) error {
+	errStr := ""
+	if fetchErr != nil {
+		errStr = fetchErr.Error()
+	}
+	cmd := "INSERT INTO " + FETCH_LOG_TABLE +
+		" (date, market, status, rows, error, attempts, updated)" +
+		" VALUES (?, ?, ?, ?, ?, 1, ?)" +
+		" ON CONFLICT (date, market) DO UPDATE SET" +
+		" status = excluded.status, rows = excluded.rows, error = excluded.error," +
+		" attempts = attempts + 1, updated = excluded.updated"
+	_, err := scanDB.Exec(cmd, d, market, status, rowNr, errStr, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// RetryFailedFetches clears the attempts of the failed fetches, so the fetcher
+// tries them again, including those it gave up on.
+func RetryFailedFetches() (n int64, err error) {
+	cmd := "UPDATE " + FETCH_LOG_TABLE + " SET attempts = 0 WHERE status = ?"
+	res, err := scanDB.Exec(cmd, FETCH_FAILED)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
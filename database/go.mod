@@ -1,3 +1,8 @@
 module myDatabase
 
 go 1.21.3
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/crypto v0.31.0
+)
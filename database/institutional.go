@@ -0,0 +1,50 @@
+package myDatabase
+
+import (
+	"database/sql"
+)
+
+const INSTITUTIONAL_TABLE string = "institutional"
+
+const INSTITUTIONAL_COLUMNS = "id, date, code, foreign_net, trust_net, dealer_net, total_net"
+
+// Institutional is the net buy of the three institutional investor groups
+// (三大法人) in one stock on one day, in shares. Selling is negative.
+type Institutional struct {
+	Date    Date   `json:"date"`
+	Code    string `json:"code"`
+	Foreign int64  `json:"foreign"` // 外資及陸資, dealers included
+	Trust   int64  `json:"trust"`   // 投信
+	Dealer  int64  `json:"dealer"`  // 自營商
+	Total   int64  `json:"total"`
+}
+
+func genInstitutional(rows *sql.Rows) (list []Institutional, err error) {
+	for rows.Next() {
+		var in Institutional
+		var Id int
+		err := rows.Scan(&Id, &in.Date, &in.Code, &in.Foreign, &in.Trust, &in.Dealer, &in.Total)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, in)
+	}
+	return list, nil
+}
+
+// PutInstitutional stores list, replacing stored rows of the same date and
+// code.
+func PutInstitutional(list []Institutional) error {
+	cmd := upsertCmd(INSTITUTIONAL_TABLE, []string{"date", "code"}, "foreign_net", "trust_net", "dealer_net", "total_net")
+	return putRows(cmd, list, func(in Institutional) string { return in.Code }, func(in Institutional) []any {
+		return []any{in.Date, in.Code, in.Foreign, in.Trust, in.Dealer, in.Total}
+	})
+}
+
+// GetInstitutionalRange returns the rows of code within r, oldest first.
+func GetInstitutionalRange(code string, r DateRange) ([]Institutional, error) {
+	if err := ValidateCode(code); err != nil {
+		return nil, err
+	}
+	return queryRange(scanDB, genInstitutional, INSTITUTIONAL_TABLE, INSTITUTIONAL_COLUMNS, r, "code = ?", code)
+}
@@ -0,0 +1,164 @@
+package myDatabase
+
+import (
+	"database/sql"
+	"sync"
+)
+
+const LEDGER_MARK_TABLE = "ledgermark"
+
+// Watermark of the ledger engine. Holdings and realized tables reflect every
+// transaction up to LastId, and no processed transaction is dated after LastDate.
+type LedgerMark struct {
+	LastId   int
+	LastDate Date
+}
+
+// A ledger update running inside one SQLite transaction. It holds ledgerMu
+// until its first Commit or Rollback.
+type LedgerTx struct {
+	tx   *sql.Tx
+	done bool
+}
+
+// Ledger units run one at a time, so two writers never meet inside SQLite.
+var ledgerMu sync.Mutex
+
+func BeginLedger() (*LedgerTx, error) {
+	ledgerMu.Lock()
+	tx, err := db.Begin()
+	if err != nil {
+		ledgerMu.Unlock()
+		return nil, err
+	}
+	return &LedgerTx{tx: tx}, nil
+}
+
+// RunLedger runs fn as one unit of work. Everything fn did is committed if it
+// returns nil, and rolled back otherwise.
+func RunLedger(fn func(l *LedgerTx) error) (err error) {
+	l, err := BeginLedger()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// A failed Commit has ended the unit already.
+		if err != nil && !l.done {
+			l.Rollback()
+		}
+	}()
+
+	if err = fn(l); err != nil {
+		return err
+	}
+	return l.Commit()
+}
+
+// Release ledgerMu, once.
+func (l *LedgerTx) end() {
+	if !l.done {
+		l.done = true
+		ledgerMu.Unlock()
+	}
+}
+
+func (l *LedgerTx) Commit() error {
+	if l.done {
+		return sql.ErrTxDone
+	}
+	defer l.end()
+	return l.tx.Commit()
+}
+
+func (l *LedgerTx) Rollback() error {
+	if l.done {
+		return sql.ErrTxDone
+	}
+	defer l.end()
+	return l.tx.Rollback()
+}
+
+// AddTransaction records t and returns its id.
+func (l *LedgerTx) AddTransaction(t Transaction) (int, error) {
+	return addTransaction(l.tx, t)
+}
+
+// GetLedgerMark returns a zero mark if the ledger was never processed.
+func (l *LedgerTx) GetLedgerMark() (mark LedgerMark, err error) {
+	cmd := "SELECT lastid, lastdate FROM " + LEDGER_MARK_TABLE + " WHERE id = 1"
+	err = l.tx.QueryRow(cmd).Scan(&mark.LastId, &mark.LastDate)
+	if err == sql.ErrNoRows {
+		return mark, nil
+	}
+	return mark, err
+}
+
+func (l *LedgerTx) SetLedgerMark(mark LedgerMark) error {
+	cmd := "INSERT OR REPLACE INTO " + LEDGER_MARK_TABLE +
+		" (id, lastid, lastdate) VALUES (1, ?, ?)"
+	_, err := l.tx.Exec(cmd, mark.LastId, mark.LastDate)
+	return err
+}
+
+// Transactions not yet seen by the ledger engine, in ledger order.
+func (l *LedgerTx) GetTransactionsAfterId(id int) (transactions []Transaction, err error) {
+	cmd := "SELECT " + TRANS_COLUMNS + " FROM " + TABLENAME +
+		" WHERE id > ?" +
+		" ORDER BY date, id"
+	rows, err := l.tx.Query(cmd, id)
+	if err != nil {
+		return transactions, err
+	}
+	defer rows.Close()
+	return genResult(rows)
+}
+
+// Transactions within r, in ledger order.
+func (l *LedgerTx) GetTransactionRange(r DateRange) ([]Transaction, error) {
+	return queryRange(l.tx, genResult, TABLENAME, TRANS_COLUMNS, r, "")
+}
+
+// Replace all holdings by the given lots.
+func (l *LedgerTx) ResetHoldings(hs []Holding) error {
+	_, err := l.tx.Exec("DELETE FROM " + HOLDING_TABLENAME)
+	if err != nil {
+		return err
+	}
+	return l.addHoldings(hs)
+}
+
+func (l *LedgerTx) DeleteRealizedSince(d Date) error {
+	cmd := "DELETE FROM " + REALIZED_TABLENAME + " WHERE date >= ?"
+	_, err := l.tx.Exec(cmd, d)
+	return err
+}
+
+func (l *LedgerTx) GetHolding(c string) ([]Holding, error) {
+	return getHolding(l.tx, c)
+}
+
+func (l *LedgerTx) AddHolding(h Holding) error {
+	return addHolding(l.tx, h)
+}
+
+func (l *LedgerTx) addHoldings(hs []Holding) error {
+	for _, h := range hs {
+		if err := addHolding(l.tx, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceHoldings makes hs, in buy order, the holdings of code.
+func (l *LedgerTx) ReplaceHoldings(code string, hs []Holding) error {
+	_, err := l.tx.Exec("DELETE FROM "+HOLDING_TABLENAME+" WHERE code = ?", code)
+	if err != nil {
+		return err
+	}
+	return l.addHoldings(hs)
+}
+
+func (l *LedgerTx) AddRealized(h Holding) error {
+	return addRealized(l.tx, h)
+}
@@ -4,55 +4,103 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"math"
 )
 
 const TABLENAME = "tansaction"
 const HOLDING_TABLENAME = "holdings"
 const REALIZED_TABLENAME = "realized"
 
+// Column lists in the order genResult and genHolding scan them.
+const TRANS_COLUMNS = "id, code, date, direction, price, quantity, fee, tax, total, net"
+const HOLDING_COLUMNS = "id, code, date, quantity, net"
+
 type Transaction struct {
-	Code      string  `json:"code"`
-	Year      int     `json:"year"`
-	Month     int     `json:"month"`
-	Day       int     `json:"day"`
-	Direction bool    `json:"direction"`
-	Price     float64 `json:"price"`
-	Quantity  int     `json:"quantity"`
-	Fee       int     `json:"fee"`
-	Tax       int     `json:"tax"`
-	Total     int     `json:"total"`
-	Net       int     `json:"net"`
+	Id        int    `json:"id,omitempty"`
+	Code      string `json:"code"`
+	Date      Date   `json:"date"`
+	Direction bool   `json:"direction"`
+	Price     Price  `json:"price"`
+	Quantity  int    `json:"quantity"`
+	Fee       Money  `json:"fee"`
+	Tax       Money  `json:"tax"`
+	Total     Money  `json:"total"`
+	Net       Money  `json:"net"`
 }
 
 type Holding struct {
 	Code     string `json:"code"`
-	Year     int    `json:"year"`
-	Month    int    `json:"month"`
-	Day      int    `json:"day"`
+	Date     Date   `json:"date"`
 	Quantity int    `json:"quantity"`
-	Net      int    `json:"net"`
+	Net      Money  `json:"net"`
 }
 
 var db *sql.DB
 var scanDB *sql.DB
 
-func InitMyDB() {
+// Either *sql.DB or *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Where the databases and their snapshots live.
+type Config struct {
+	MainPath   string
+	DailyPath  string
+	BackupDir  string
+	BackupKeep int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MainPath:   "./database/" + MAIN_DB_FILE,
+		DailyPath:  "./database/" + DAILY_DB_FILE,
+		BackupDir:  "./database/backup",
+		BackupKeep: 7,
+	}
+}
+
+var config Config = DefaultConfig()
+
+// OpenMyDB opens both databases without touching their schema.
+func OpenMyDB(c Config) {
+	openMyDB(c, func(path string) string { return path })
+}
+
+// OpenMyDBReadOnly opens the databases for reading only, so a missing file
+// is not created and nothing is written.
+func OpenMyDBReadOnly(c Config) {
+	openMyDB(c, readOnlyDSN)
+}
+
+func readOnlyDSN(path string) string {
+	return "file:" + path + "?mode=ro"
+}
+
+func openMyDB(c Config, dsn func(path string) string) {
 	var err error
-	db, err = sql.Open("sqlite3", "./database/transactionDB.sqlite")
+	config = c
+	invalidateCalendar()
+	db, err = sql.Open("sqlite3", dsn(config.MainPath))
 	if err != nil {
 		log.Fatal(err)
 	}
-	scanDB, err = sql.Open("sqlite3", "./database/dailyDB.sqlite")
+	scanDB, err = sql.Open("sqlite3", dsn(config.DailyPath))
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
-	initTransTbl()
-	initRefTbl()
-	initHoldingTbl()
-	initRealizedTbl()
-	initStockTbl()
+func InitMyDB(c Config) {
+	OpenMyDB(c)
+
+	if err := migrate(db, MAIN_DB_NAME, mainMigrations); err != nil {
+		log.Fatalf("Main: Failed to migrate: %v", err)
+	}
+	if err := migrate(scanDB, DAILY_DB_NAME, dailyMigrations); err != nil {
+		log.Fatalf("DQ: Failed to migrate: %v", err)
+	}
 
 	fmt.Println("Database and table initialized.")
 }
@@ -70,84 +118,46 @@ func VacuumDB() error {
 }
 
 func ResetTbl(tblName string) error {
+	switch tblName {
+	case TABLENAME, HOLDING_TABLENAME, REALIZED_TABLENAME:
+	default:
+		return &ValidationError{Field: "table", Value: tblName, Reason: "not a ledger table"}
+	}
 	cmd := "DELETE FROM " + tblName
 	_, err := db.Exec(cmd)
 	if err != nil {
 		return err
 	}
-	cmd = "UPDATE sqlite_sequence SET seq = 0 WHERE name = '" + tblName + "'"
-	_, err = db.Exec(cmd)
+	cmd = "UPDATE sqlite_sequence SET seq = 0 WHERE name = ?"
+	_, err = db.Exec(cmd, tblName)
 	return err
 }
 
-func initTransTbl() {
-	createTableSQL := `CREATE TABLE IF NOT EXISTS ` + TABLENAME + ` (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		code TEXT NOT NULL,
-		year INTEGER NOT NULL,
-		month INTEGER NOT NULL,
-		day INTEGER NOT NULL,
-		direction BOOLEAN NOT NULL,
-		price REAL NOT NULL,
-		quantity INTEGER NOT NULL,
-		fee INTEGER NOT NULL,
-		tax INTEGER NOT NULL,
-		total INTEGER NOT NULL,
-		net INTEGER NOT NULL
-	    );`
-
-	if _, err := db.Exec(createTableSQL); err != nil {
-		log.Fatalf("Main: Failed to create transaction table: %v", err)
-	}
-}
-
-func initHoldingTbl() {
-	cmd := `CREATE TABLE IF NOT EXISTS ` + HOLDING_TABLENAME + ` (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		code TEXT NOT NULL,
-		year INTEGER NOT NULL,
-		month INTEGER NOT NULL,
-		day INTEGER NOT NULL,
-		quantity INTEGER NOT NULL,
-		net INTEGER NOT NULL
-	    );`
-
-	if _, err := db.Exec(cmd); err != nil {
-		log.Fatalf("Main: Failed to create hoildings table: %v", err)
-	}
+func AddTransaction(t Transaction) (err error) {
+	_, err = addTransaction(db, t)
+	return err
 }
 
-func initRealizedTbl() {
-	cmd := `CREATE TABLE IF NOT EXISTS ` + REALIZED_TABLENAME + ` (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		code TEXT NOT NULL,
-		year INTEGER NOT NULL,
-		month INTEGER NOT NULL,
-		day INTEGER NOT NULL,
-		quantity INTEGER NOT NULL,
-		net INTEGER NOT NULL
-	    );`
-
-	if _, err := db.Exec(cmd); err != nil {
-		log.Fatalf("Main: Failed to create realized table: %v", err)
+func addTransaction(q querier, t Transaction) (id int, err error) {
+	if err = t.Validate(); err != nil {
+		return 0, err
 	}
-}
-
-func AddTransaction(t Transaction) (err error) {
 	cmd := "INSERT INTO " + TABLENAME +
-		" (code, year, month, day, direction, price, quantity, fee, tax, total, net)" +
-		" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		" (code, date, direction, price, quantity, fee, tax, total, net)" +
+		" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
-	_, err = db.Exec(cmd, t.Code, t.Year, t.Month, t.Day, t.Direction, t.Price, t.Quantity, t.Fee, t.Tax, t.Total, t.Net)
-
-	return err
+	res, err := q.Exec(cmd, t.Code, t.Date, t.Direction, t.Price, t.Quantity, t.Fee, t.Tax, t.Total, t.Net)
+	if err != nil {
+		return 0, err
+	}
+	lastId, err := res.LastInsertId()
+	return int(lastId), err
 }
 
 func genResult(rows *sql.Rows) (transactions []Transaction, err error) {
 	for rows.Next() {
 		var t Transaction
-		var Id int
-		err := rows.Scan(&Id, &t.Code, &t.Year, &t.Month, &t.Day, &t.Direction, &t.Price, &t.Quantity, &t.Fee, &t.Tax, &t.Total, &t.Net)
+		err := rows.Scan(&t.Id, &t.Code, &t.Date, &t.Direction, &t.Price, &t.Quantity, &t.Fee, &t.Tax, &t.Total, &t.Net)
 		if err != nil {
 			return transactions, err
 		}
@@ -159,7 +169,7 @@ func genHolding(rows *sql.Rows) (holdings []Holding, err error) {
 	for rows.Next() {
 		var h Holding
 		var Id int
-		err := rows.Scan(&Id, &h.Code, &h.Year, &h.Month, &h.Day, &h.Quantity, &h.Net)
+		err := rows.Scan(&Id, &h.Code, &h.Date, &h.Quantity, &h.Net)
 		if err != nil {
 			return holdings, err
 		}
@@ -169,7 +179,7 @@ func genHolding(rows *sql.Rows) (holdings []Holding, err error) {
 }
 
 func ScanTransaction() (transactions []Transaction, err error) {
-	cmd := "SELECT * FROM " + TABLENAME + " ORDER BY year, month, day"
+	cmd := "SELECT " + TRANS_COLUMNS + " FROM " + TABLENAME + " ORDER BY date, id"
 	rows, err := db.Query(cmd)
 	if err != nil {
 		return transactions, err
@@ -178,26 +188,20 @@ func ScanTransaction() (transactions []Transaction, err error) {
 	return genResult(rows)
 }
 
-func GetTransactions(y int, m int, d int) (transactions []Transaction, err error) {
-	cmd := fmt.Sprintf("SELECT * FROM %s "+
-		"WHERE year > %d OR "+
-		"(year = %d AND month > %d) OR "+
-		"(year = %d AND month = %d AND day <= %d)",
-		TABLENAME, y, y, m, y, m, d)
-	rows, err := db.Query(cmd)
-	if err != nil {
-		return transactions, err
-	}
-	defer rows.Close()
-	return genResult(rows)
+// GetTransactionRange returns transactions within r, in ledger order.
+func GetTransactionRange(r DateRange) ([]Transaction, error) {
+	return queryRange(db, genResult, TABLENAME, TRANS_COLUMNS, r, "")
 }
 
 func GetHolding(c string) (hs []Holding, err error) {
-	cmd := fmt.Sprintf("SELECT * FROM %s "+
-		" WHERE code = '%s'"+
-		" ORDER BY year ASC, month ASC, day ASC",
-		HOLDING_TABLENAME, c)
-	rows, err := db.Query(cmd)
+	return getHolding(db, c)
+}
+
+func getHolding(q querier, c string) (hs []Holding, err error) {
+	cmd := "SELECT " + HOLDING_COLUMNS + " FROM " + HOLDING_TABLENAME +
+		" WHERE code = ?" +
+		" ORDER BY date ASC, id ASC"
+	rows, err := q.Query(cmd, c)
 	if err != nil {
 		return hs, err
 	}
@@ -206,9 +210,8 @@ func GetHolding(c string) (hs []Holding, err error) {
 }
 
 func GetHoldingAll() (hs []Holding, err error) {
-	cmd := fmt.Sprintf("SELECT * FROM %s "+
-		" ORDER BY code",
-		HOLDING_TABLENAME)
+	cmd := "SELECT " + HOLDING_COLUMNS + " FROM " + HOLDING_TABLENAME +
+		" ORDER BY code, date, id"
 	rows, err := db.Query(cmd)
 	if err != nil {
 		return hs, err
@@ -218,25 +221,32 @@ func GetHoldingAll() (hs []Holding, err error) {
 }
 
 func AddHolding(h Holding) error {
+	return addHolding(db, h)
+}
+
+func addHolding(q querier, h Holding) error {
 	cmd := "INSERT INTO " + HOLDING_TABLENAME +
-		" (code, year, month, day, quantity, net)" +
-		" VALUES (?, ?, ?, ?, ?, ?)"
+		" (code, date, quantity, net)" +
+		" VALUES (?, ?, ?, ?)"
 
-	_, err := db.Exec(cmd, h.Code, h.Year, h.Month, h.Day, h.Quantity, h.Net)
+	_, err := q.Exec(cmd, h.Code, h.Date, h.Quantity, h.Net)
 	return err
 }
 
 func DecHolding(old Holding, nr int) (remain int, err error) {
+	return decHolding(db, old, nr)
+}
+
+func decHolding(q querier, old Holding, nr int) (remain int, err error) {
 	var cmd string
 	if nr >= old.Quantity {
-		cmd = fmt.Sprintf("DELETE FROM "+HOLDING_TABLENAME+
-			" WHERE rowid IN (SELECT rowid"+
-			"	FROM "+HOLDING_TABLENAME+
-			"	WHERE code = '%s'"+
-			"	ORDER BY year ASC, month ASC, day ASC"+
-			"	LIMIT 1)",
-			old.Code)
-		_, err = db.Exec(cmd)
+		cmd = "DELETE FROM " + HOLDING_TABLENAME +
+			" WHERE rowid IN (SELECT rowid" +
+			"	FROM " + HOLDING_TABLENAME +
+			"	WHERE code = ?" +
+			"	ORDER BY date ASC, id ASC" +
+			"	LIMIT 1)"
+		_, err = q.Exec(cmd, old.Code)
 		if err != nil {
 			return -1, err
 		}
@@ -244,16 +254,15 @@ func DecHolding(old Holding, nr int) (remain int, err error) {
 	}
 
 	qty := old.Quantity - nr
-	net := int(math.Round(float64(old.Net) * (float64(qty) / float64(old.Quantity))))
-	cmd = fmt.Sprintf("UPDATE "+HOLDING_TABLENAME+
-		" SET quantity = %d, net = %d"+
-		" WHERE rowid IN (SELECT rowid"+
-		"	FROM "+HOLDING_TABLENAME+
-		"	WHERE code = '%s'"+
-		"	ORDER BY year ASC, month ASC, day ASC"+
-		"	LIMIT 1)",
-		qty, net, old.Code)
-	_, err = db.Exec(cmd)
+	_, net := Prorate(old.Net, nr, old.Quantity)
+	cmd = "UPDATE " + HOLDING_TABLENAME +
+		" SET quantity = ?, net = ?" +
+		" WHERE rowid IN (SELECT rowid" +
+		"	FROM " + HOLDING_TABLENAME +
+		"	WHERE code = ?" +
+		"	ORDER BY date ASC, id ASC" +
+		"	LIMIT 1)"
+	_, err = q.Exec(cmd, qty, net, old.Code)
 	if err != nil {
 		return -1, err
 	}
@@ -261,33 +270,26 @@ func DecHolding(old Holding, nr int) (remain int, err error) {
 }
 
 func AddRealized(h Holding) error {
+	return addRealized(db, h)
+}
+
+func addRealized(q querier, h Holding) error {
 	cmd := "INSERT INTO " + REALIZED_TABLENAME +
-		" (code, year, month, day, quantity, net)" +
-		" VALUES (?, ?, ?, ?, ?, ?)"
+		" (code, date, quantity, net)" +
+		" VALUES (?, ?, ?, ?)"
 
-	_, err := db.Exec(cmd, h.Code, h.Year, h.Month, h.Day, h.Quantity, h.Net)
+	_, err := q.Exec(cmd, h.Code, h.Date, h.Quantity, h.Net)
 	return err
 }
 
-func GetRelized(y int, m int, d int) ([]Holding, error) {
-	cmd := fmt.Sprintf("SELECT * FROM %s "+
-		"WHERE year > %d OR "+
-		"(year = %d AND month > %d) OR "+
-		"(year = %d AND month = %d AND day <= %d)",
-		REALIZED_TABLENAME, y, y, m, y, m, d)
-	rows, err := db.Query(cmd)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return genHolding(rows)
+// GetRealizedRange returns realized entries within r, oldest first.
+func GetRealizedRange(r DateRange) ([]Holding, error) {
+	return queryRange(db, genHolding, REALIZED_TABLENAME, HOLDING_COLUMNS, r, "")
 }
 
-func CreateTransaction(y int, m int, d int, dir bool, code string, price float64, qty int, fee int) (t Transaction) {
+func CreateTransaction(date Date, dir bool, code string, price Price, qty int, fee Money) (t Transaction) {
 	t = Transaction{
-		Year:      y,
-		Month:     m,
-		Day:       d,
+		Date:      date,
 		Direction: dir,
 		Code:      code,
 		Price:     price,
@@ -295,13 +297,13 @@ func CreateTransaction(y int, m int, d int, dir bool, code string, price float64
 		Fee:       fee,
 	}
 
-	t.Total = int(math.Round(t.Price * float64(t.Quantity)))
+	t.Total = t.Price.Mul(t.Quantity)
 
 	if t.Direction {
 		t.Tax = 0
 		t.Net = t.Total + t.Fee
 	} else {
-		t.Tax = int(math.Round(float64(t.Total) * 0.003))
+		t.Tax = t.Total.MulRatio(SELL_TAX_NUM, SELL_TAX_DEN)
 		t.Net = t.Total - t.Fee - t.Tax
 	}
 
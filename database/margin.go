@@ -0,0 +1,71 @@
+package myDatabase
+
+import (
+	"database/sql"
+)
+
+const MARGIN_TABLE string = "margin"
+
+const MARGIN_COLUMNS = "id, date, code, margin, margin_limit, short, short_limit"
+
+// Margin is the margin purchase (融資) and short sale (融券) balance of one
+// stock at the close of one day, in lots.
+type Margin struct {
+	Date        Date   `json:"date"`
+	Code        string `json:"code"`
+	Margin      int64  `json:"margin"`
+	MarginLimit int64  `json:"marginLimit"`
+	Short       int64  `json:"short"`
+	ShortLimit  int64  `json:"shortLimit"`
+}
+
+func ratio(a int64, b int64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// MarginUsage is the used part of the margin limit (資使用率).
+func (m Margin) MarginUsage() float64 {
+	return ratio(m.Margin, m.MarginLimit)
+}
+
+// ShortUsage is the used part of the short limit (券使用率).
+func (m Margin) ShortUsage() float64 {
+	return ratio(m.Short, m.ShortLimit)
+}
+
+// ShortMarginRatio is the short balance against the margin balance (券資比).
+func (m Margin) ShortMarginRatio() float64 {
+	return ratio(m.Short, m.Margin)
+}
+
+func genMargin(rows *sql.Rows) (list []Margin, err error) {
+	for rows.Next() {
+		var m Margin
+		var Id int
+		err := rows.Scan(&Id, &m.Date, &m.Code, &m.Margin, &m.MarginLimit, &m.Short, &m.ShortLimit)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+// PutMargins stores list, replacing stored rows of the same date and code.
+func PutMargins(list []Margin) error {
+	cmd := upsertCmd(MARGIN_TABLE, []string{"date", "code"}, "margin", "margin_limit", "short", "short_limit")
+	return putRows(cmd, list, func(m Margin) string { return m.Code }, func(m Margin) []any {
+		return []any{m.Date, m.Code, m.Margin, m.MarginLimit, m.Short, m.ShortLimit}
+	})
+}
+
+// GetMarginRange returns the balances of code within r, oldest first.
+func GetMarginRange(code string, r DateRange) ([]Margin, error) {
+	if err := ValidateCode(code); err != nil {
+		return nil, err
+	}
+	return queryRange(scanDB, genMargin, MARGIN_TABLE, MARGIN_COLUMNS, r, "code = ?", code)
+}
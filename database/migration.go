@@ -0,0 +1,414 @@
+package myDatabase
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+const SCHEMA_VERSION_TABLE = "schema_version"
+
+const MAIN_DB_NAME = "main"
+const DAILY_DB_NAME = "daily"
+
+// A forward-only schema change. Versions of one database start at 1 and
+// increase by one. Never edit a released migration; append a new one instead.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+type PendingMigration struct {
+	DB      string `json:"db"`
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+}
+
+func execAll(cmds ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, cmd := range cmds {
+			if _, err := tx.Exec(cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+var mainMigrations = []migration{
+	{1, "initial tables", execAll(
+		`CREATE TABLE IF NOT EXISTS `+TABLENAME+` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL,
+		year INTEGER NOT NULL,
+		month INTEGER NOT NULL,
+		day INTEGER NOT NULL,
+		direction BOOLEAN NOT NULL,
+		price REAL NOT NULL,
+		quantity INTEGER NOT NULL,
+		fee INTEGER NOT NULL,
+		tax INTEGER NOT NULL,
+		total INTEGER NOT NULL,
+		net INTEGER NOT NULL
+	    );`,
+		`CREATE TABLE IF NOT EXISTS reference (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL,
+		name TEXT NOT NULL
+	    );`,
+		`CREATE TABLE IF NOT EXISTS `+HOLDING_TABLENAME+` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL,
+		year INTEGER NOT NULL,
+		month INTEGER NOT NULL,
+		day INTEGER NOT NULL,
+		quantity INTEGER NOT NULL,
+		net INTEGER NOT NULL
+	    );`,
+		`CREATE TABLE IF NOT EXISTS `+REALIZED_TABLENAME+` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL,
+		year INTEGER NOT NULL,
+		month INTEGER NOT NULL,
+		day INTEGER NOT NULL,
+		quantity INTEGER NOT NULL,
+		net INTEGER NOT NULL
+	    );`,
+	)},
+	{2, "ledger watermark", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + LEDGER_MARK_TABLE + ` (
+		id INTEGER PRIMARY KEY,
+		lastid INTEGER NOT NULL,
+		lastdate TEXT NOT NULL
+	    );`,
+	)},
+	{3, "single date column", func(tx *sql.Tx) error {
+		for _, tbl := range []string{TABLENAME, HOLDING_TABLENAME, REALIZED_TABLENAME} {
+			if err := toDateColumn(tx, tbl); err != nil {
+				return err
+			}
+		}
+		return execAll(
+			"CREATE INDEX IF NOT EXISTS idx_"+TABLENAME+"_date ON "+TABLENAME+" (date, id)",
+			"CREATE INDEX IF NOT EXISTS idx_"+HOLDING_TABLENAME+"_code_date ON "+HOLDING_TABLENAME+" (code, date, id)",
+			"CREATE INDEX IF NOT EXISTS idx_"+REALIZED_TABLENAME+"_date ON "+REALIZED_TABLENAME+" (date)",
+			"UPDATE "+LEDGER_MARK_TABLE+" SET lastdate ="+
+				" substr(lastdate, 1, 4) || '-' || substr(lastdate, 5, 2) || '-' || substr(lastdate, 7, 2)"+
+				" WHERE length(lastdate) = 8",
+		)(tx)
+	}},
+	{4, "fixed-point price", func(tx *sql.Tx) error {
+		return toFixedPrice(tx, TABLENAME, "price")
+	}},
+}
+
+var dailyMigrations = []migration{
+	{1, "checked date table", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + CHECKED_DATE_TABLE + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		startdate TEXT NOT NULL
+	    )`,
+	)},
+	{2, "single date column", func(tx *sql.Tx) error {
+		tables, err := stockTables(tx)
+		if err != nil {
+			return err
+		}
+		for _, tbl := range tables {
+			cmd := "DELETE FROM " + tbl + " WHERE id NOT IN" +
+				" (SELECT MIN(id) FROM " + tbl + " GROUP BY year, month, day)"
+			if _, err = tx.Exec(cmd); err != nil {
+				return err
+			}
+			if err = toDateColumn(tx, tbl); err != nil {
+				return err
+			}
+			cmd = "CREATE UNIQUE INDEX IF NOT EXISTS idx_" + tbl + "_date ON " + tbl + " (date)"
+			if _, err = tx.Exec(cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{3, "trading calendar", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + CALENDAR_TABLE + ` (
+		date TEXT PRIMARY KEY,
+		open INTEGER NOT NULL,
+		source TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT ''
+	    )`,
+	)},
+	// The per-stock tables do not tell which market a code trades on, so no
+	// day is logged as fetched for one market or the other. Days in the
+	// fetch window are fetched again per market; the days already stored are
+	// kept.
+	{4, "fetch log", execAll(
+		`CREATE TABLE IF NOT EXISTS `+FETCH_LOG_TABLE+` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		market TEXT NOT NULL,
+		status TEXT NOT NULL,
+		rows INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		updated TEXT NOT NULL,
+		UNIQUE (date, market)
+	    )`,
+		"DROP TABLE IF EXISTS "+CHECKED_DATE_TABLE,
+	)},
+	{5, "ex-rights", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + EXRIGHT_TABLE + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		code TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		prev_close REAL NOT NULL,
+		ref_price REAL NOT NULL,
+		UNIQUE (date, code)
+	    )`,
+	)},
+	{6, "institutional trades", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + INSTITUTIONAL_TABLE + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		code TEXT NOT NULL,
+		foreign_net INTEGER NOT NULL,
+		trust_net INTEGER NOT NULL,
+		dealer_net INTEGER NOT NULL,
+		total_net INTEGER NOT NULL,
+		UNIQUE (date, code)
+	    )`,
+	)},
+	{7, "margin balance", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + MARGIN_TABLE + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		code TEXT NOT NULL,
+		margin INTEGER NOT NULL,
+		margin_limit INTEGER NOT NULL,
+		short INTEGER NOT NULL,
+		short_limit INTEGER NOT NULL,
+		UNIQUE (date, code)
+	    )`,
+	)},
+	{8, "valuation", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + VALUATION_TABLE + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		code TEXT NOT NULL,
+		pe REAL NOT NULL,
+		pb REAL NOT NULL,
+		yield REAL NOT NULL,
+		UNIQUE (date, code)
+	    )`,
+	)},
+	{9, "monthly revenue", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + REVENUE_TABLE + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		code TEXT NOT NULL,
+		revenue INTEGER NOT NULL,
+		mom REAL NOT NULL,
+		yoy REAL NOT NULL,
+		UNIQUE (date, code)
+	    )`,
+	)},
+	{10, "rejected rows", execAll(
+		`CREATE TABLE IF NOT EXISTS ` + REJECT_TABLE + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		market TEXT NOT NULL,
+		code TEXT NOT NULL,
+		row TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		UNIQUE (date, market, row)
+	    )`,
+	)},
+	{11, "single quotes table", func(tx *sql.Tx) error {
+		err := execAll(
+			`CREATE TABLE IF NOT EXISTS `+QUOTE_TABLE+` (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT NOT NULL,
+			date TEXT NOT NULL,
+			volume INTEGER NOT NULL,
+			trans INTEGER NOT NULL,
+			value INTEGER NOT NULL,
+			open REAL NOT NULL,
+			high REAL NOT NULL,
+			low REAL NOT NULL,
+			close REAL NOT NULL,
+			UNIQUE (code, date)
+		    )`,
+			"CREATE INDEX IF NOT EXISTS idx_"+QUOTE_TABLE+"_date ON "+QUOTE_TABLE+" (date)",
+		)(tx)
+		if err != nil {
+			return err
+		}
+
+		tables, err := stockTables(tx)
+		if err != nil {
+			return err
+		}
+		for _, tbl := range tables {
+			code, _ := stockCode(tbl)
+			cmd := "INSERT OR IGNORE INTO " + QUOTE_TABLE + " (code, " + DQ_STORE_COLUMNS + ")" +
+				" SELECT ?, " + DQ_STORE_COLUMNS + " FROM " + tbl + " ORDER BY date"
+			if _, err = tx.Exec(cmd, code); err != nil {
+				return err
+			}
+			if _, err = tx.Exec("DROP TABLE " + tbl); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{12, "fixed-point quote prices", func(tx *sql.Tx) error {
+		err := toFixedPrice(tx, QUOTE_TABLE, "open", "high", "low", "close")
+		if err != nil {
+			return err
+		}
+		return toFixedPrice(tx, EXRIGHT_TABLE, "prev_close", "ref_price")
+	}},
+	{13, "side report code index", func(tx *sql.Tx) error {
+		// Their UNIQUE (date, code) serves a day's load; reads of one
+		// stock need code first.
+		for _, tbl := range []string{EXRIGHT_TABLE, INSTITUTIONAL_TABLE, MARGIN_TABLE, VALUATION_TABLE, REVENUE_TABLE} {
+			cmd := "CREATE INDEX IF NOT EXISTS idx_" + tbl + "_code_date ON " + tbl + " (code, date)"
+			if _, err := tx.Exec(cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// Turn the REAL dollar columns of tbl into INTEGER Price columns.
+func toFixedPrice(tx *sql.Tx, tbl string, columns ...string) error {
+	for _, col := range columns {
+		err := execAll(
+			"ALTER TABLE "+tbl+" ADD COLUMN "+col+"_fixed INTEGER NOT NULL DEFAULT 0",
+			fmt.Sprintf("UPDATE "+tbl+" SET "+col+"_fixed = CAST(round("+col+" * %d) AS INTEGER)", PRICE_SCALE),
+			"ALTER TABLE "+tbl+" DROP COLUMN "+col,
+			"ALTER TABLE "+tbl+" RENAME COLUMN "+col+"_fixed TO "+col,
+		)(tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace the year, month and day columns of tbl by one date column.
+func toDateColumn(tx *sql.Tx, tbl string) error {
+	return execAll(
+		"ALTER TABLE "+tbl+" ADD COLUMN date TEXT NOT NULL DEFAULT ''",
+		"UPDATE "+tbl+" SET date = printf('%04d-%02d-%02d', year, month, day)",
+		"ALTER TABLE "+tbl+" DROP COLUMN year",
+		"ALTER TABLE "+tbl+" DROP COLUMN month",
+		"ALTER TABLE "+tbl+" DROP COLUMN day",
+	)(tx)
+}
+
+func initSchemaVersionTbl(conn *sql.DB) error {
+	cmd := `CREATE TABLE IF NOT EXISTS ` + SCHEMA_VERSION_TABLE + ` (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied TEXT NOT NULL
+	    )`
+	_, err := conn.Exec(cmd)
+	return err
+}
+
+// Version 0 means no migration was ever applied.
+func schemaVersion(conn *sql.DB) (version int, err error) {
+	var nr int
+	cmd := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	err = conn.QueryRow(cmd, SCHEMA_VERSION_TABLE).Scan(&nr)
+	if err != nil || nr == 0 {
+		return 0, err
+	}
+
+	cmd = "SELECT IFNULL(MAX(version), 0) FROM " + SCHEMA_VERSION_TABLE
+	err = conn.QueryRow(cmd).Scan(&version)
+	return version, err
+}
+
+func pending(conn *sql.DB, ms []migration) ([]migration, error) {
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) > 0 && version > ms[len(ms)-1].version {
+		return nil, fmt.Errorf("schema version %d is newer than this program", version)
+	}
+	for i, m := range ms {
+		if m.version > version {
+			return ms[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// Apply pending migrations in order. Each one commits together with its
+// schema_version row, so a failure leaves the database at the last good version.
+func migrate(conn *sql.DB, dbName string, ms []migration) error {
+	err := initSchemaVersionTbl(conn)
+	if err != nil {
+		return err
+	}
+	todo, err := pending(conn, ms)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range todo {
+		fmt.Printf("Migrating %s database to version %d (%s)\n", dbName, m.version, m.name)
+		tx, err := conn.Begin()
+		if err != nil {
+			return err
+		}
+		err = m.up(tx)
+		if err == nil {
+			cmd := "INSERT INTO " + SCHEMA_VERSION_TABLE + " (version, name, applied) VALUES (?, ?, ?)"
+			_, err = tx.Exec(cmd, m.version, m.name, time.Now().Format(time.RFC3339))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PendingMigrations reports what InitMyDB would apply, without applying it.
+// The databases must be opened by OpenMyDB or OpenMyDBReadOnly.
+func PendingMigrations() (list []PendingMigration, err error) {
+	for _, target := range []struct {
+		name string
+		path string
+		conn *sql.DB
+		ms   []migration
+	}{
+		{MAIN_DB_NAME, config.MainPath, db, mainMigrations},
+		{DAILY_DB_NAME, config.DailyPath, scanDB, dailyMigrations},
+	} {
+		// A database not created yet has every migration ahead of it.
+		todo := target.ms
+		if _, err := os.Stat(target.path); err == nil {
+			todo, err = pending(target.conn, target.ms)
+			if err != nil {
+				return list, err
+			}
+		}
+		for _, m := range todo {
+			list = append(list, PendingMigration{DB: target.name, Version: m.version, Name: m.name})
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,146 @@
+package myDatabase
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Prices are kept in 1/PRICE_SCALE dollars.
+const PRICE_SCALE = 10000
+const PRICE_DECIMALS = 4
+
+// Securities transaction tax on sells, 3/1000 of the total.
+const SELL_TAX_NUM = 3
+const SELL_TAX_DEN = 1000
+
+// Money is an amount in whole NT dollars.
+type Money int64
+
+// Price is a fixed-point unit price with PRICE_DECIMALS decimals.
+type Price int64
+
+// a/b rounded half away from zero, like math.Round.
+func divRound(a int64, b int64) int64 {
+	if b < 0 {
+		a, b = -a, -b
+	}
+	q, r := a/b, a%b
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= b {
+		if a < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+func (m Money) MulRatio(num int64, den int64) Money {
+	return Money(divRound(int64(m)*num, den))
+}
+
+// Prorate splits m for part out of whole units. taken + rest always equals m,
+// so taking every unit of a lot piece by piece adds up to exactly m.
+func Prorate(m Money, part int, whole int) (taken Money, rest Money) {
+	if part >= whole {
+		return m, 0
+	}
+	taken = Money(divRound(int64(m)*int64(part), int64(whole)))
+	return taken, m - taken
+}
+
+func ParsePrice(s string) (Price, error) {
+	s = strings.TrimSpace(strings.Replace(s, ",", "", -1))
+	neg := strings.HasPrefix(s, "-")
+	intPart, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if intPart == "" && frac == "" || len(frac) > PRICE_DECIMALS {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+	frac += strings.Repeat("0", PRICE_DECIMALS-len(frac))
+
+	var ip, fp int64
+	var err error
+	if intPart != "" {
+		ip, err = strconv.ParseInt(intPart, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid price %q", s)
+		}
+	}
+	fp, err = strconv.ParseInt(frac, 10, 64)
+	if err != nil || fp < 0 {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+
+	p := Price(ip*PRICE_SCALE + fp)
+	if neg {
+		p = -p
+	}
+	return p, nil
+}
+
+func PriceFromFloat(f float64) Price {
+	return Price(math.Round(f * PRICE_SCALE))
+}
+
+func (p Price) Float64() float64 {
+	return float64(p) / PRICE_SCALE
+}
+
+func (p Price) String() string {
+	sign := ""
+	v := int64(p)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", PRICE_DECIMALS, v%PRICE_SCALE), "0")
+	if frac == "" {
+		return fmt.Sprintf("%s%d", sign, v/PRICE_SCALE)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, v/PRICE_SCALE, frac)
+}
+
+func (p Price) MulRatio(num int64, den int64) Price {
+	return Price(divRound(int64(p)*num, den))
+}
+
+// Total of qty shares, rounded to whole dollars.
+func (p Price) Mul(qty int) Money {
+	return Money(divRound(int64(p)*int64(qty), PRICE_SCALE))
+}
+
+func (p Price) Value() (driver.Value, error) {
+	return int64(p), nil
+}
+
+func (p *Price) Scan(src any) (err error) {
+	switch v := src.(type) {
+	case int64:
+		*p = Price(v)
+	case float64:
+		*p = PriceFromFloat(v)
+	case string:
+		*p, err = ParsePrice(v)
+	case []byte:
+		*p, err = ParsePrice(string(v))
+	default:
+		err = fmt.Errorf("cannot scan %T into Price", src)
+	}
+	return err
+}
+
+// Prices go out as plain JSON numbers, and are read from numbers or strings.
+func (p Price) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Price) UnmarshalJSON(b []byte) (err error) {
+	*p, err = ParsePrice(strings.Trim(string(b), `"`))
+	return err
+}
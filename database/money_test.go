@@ -0,0 +1,99 @@
+package myDatabase
+
+import "testing"
+
+func TestDivRound(t *testing.T) {
+	cases := []struct {
+		a, b, want int64
+	}{
+		{10, 4, 3},
+		{9, 4, 2},
+		{-10, 4, -3},
+		{-9, 4, -2},
+		{10, -4, -3},
+		{-10, -4, 3},
+		{7, 7, 1},
+		{0, 3, 0},
+		{1, 3, 0},
+		{2, 3, 1},
+	}
+	for _, c := range cases {
+		if got := divRound(c.a, c.b); got != c.want {
+			t.Errorf("divRound(%d, %d) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestProrate(t *testing.T) {
+	cases := []struct {
+		m           Money
+		part, whole int
+		taken, rest Money
+	}{
+		{1000, 1, 3, 333, 667},
+		{1000, 2, 3, 667, 333},
+		{1000, 3, 3, 1000, 0},
+		{1000, 5, 3, 1000, 0},
+		{-1000, 1, 3, -333, -667},
+		{10070, 333, 1000, 3353, 6717},
+	}
+	for _, c := range cases {
+		taken, rest := Prorate(c.m, c.part, c.whole)
+		if taken != c.taken || rest != c.rest {
+			t.Errorf("Prorate(%d, %d, %d) = %d, %d, want %d, %d",
+				c.m, c.part, c.whole, taken, rest, c.taken, c.rest)
+		}
+	}
+}
+
+// Selling a lot piece by piece must take exactly its whole net.
+func TestProrateAddsUp(t *testing.T) {
+	var m Money = 10070
+	whole := 1000
+	var sum Money
+	for _, part := range []int{333, 333, 334} {
+		taken, rest := Prorate(m, part, whole)
+		sum += taken
+		m, whole = rest, whole-part
+	}
+	if sum != 10070 || m != 0 {
+		t.Errorf("pieces sum to %d, %d left", sum, m)
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	cases := []struct {
+		s    string
+		want Price
+	}{
+		{"10", 100000},
+		{"10.05", 100500},
+		{"0.0001", 1},
+		{".5", 5000},
+		{"1,234.5", 12345000},
+		{" 775.50 ", 7755000},
+		{"-3.2", -32000},
+		{"12.", 120000},
+	}
+	for _, c := range cases {
+		got, err := ParsePrice(c.s)
+		if err != nil || got != c.want {
+			t.Errorf("ParsePrice(%q) = %d, %v, want %d", c.s, got, err, c.want)
+		}
+	}
+
+	for _, s := range []string{"", ".", "--", "1.23456", "abc", "1.2.3", "1.-2"} {
+		if p, err := ParsePrice(s); err == nil {
+			t.Errorf("ParsePrice(%q) = %d, want an error", s, p)
+		}
+	}
+}
+
+func TestPriceString(t *testing.T) {
+	for _, s := range []string{"10", "10.05", "0.0001", "-3.2", "775.5"} {
+		p, err := ParsePrice(s)
+		if err != nil || p.String() != s {
+			t.Errorf("ParsePrice(%q).String() = %q, %v", s, p.String(), err)
+		}
+	}
+}
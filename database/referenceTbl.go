@@ -4,50 +4,92 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"strings"
 )
 
-func initRefTbl() (err error) {
-	createRefSQL := `CREATE TABLE IF NOT EXISTS reference (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		code TEXT NOT NULL,
-		name TEXT NOT NULL
-	    );`
-	if _, err = db.Exec(createRefSQL); err != nil {
-		log.Fatalf("Ref: Failed to create ref-table: %v", err)
-	}
-	return err
-}
-
 func RefLookupCodeByName(name string) (code string, err error) {
 	query := `SELECT code FROM reference WHERE name = ?`
 	row := db.QueryRow(query, name)
 	err = row.Scan(&code)
 	return code, err
 }
+
+// RefCodes returns the set of codes with a name.
+func RefCodes() (map[string]bool, error) {
+	rows, err := db.Query(`SELECT code FROM reference`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := map[string]bool{}
+	for rows.Next() {
+		var code string
+		if err = rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
 func RefLookupNameByCode(code string) (name string, err error) {
 	query := `SELECT name FROM reference WHERE code = ?`
 	row := db.QueryRow(query, code)
 	err = row.Scan(&name)
 	return name, err
 }
-func refSetupCodeName(code string, name string) (err error) {
-	_, err = RefLookupCodeByName(name)
-	if err != sql.ErrNoRows {
-		return errors.New("duplicate code")
+
+var ErrNameTaken error = errors.New("duplicate code")
+
+// Name code within q. A bad code or name is a ValidationError, and a name
+// another code already has is ErrNameTaken.
+func addRef(q querier, code string, name string) error {
+	if err := ValidateCode(code); err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &ValidationError{Field: "name", Value: name, Reason: "empty"}
 	}
 
-	query := `INSERT INTO reference (code, name) VALUES (?, ?)`
-	_, err = db.Exec(query, code, name)
+	var taken string
+	err := q.QueryRow(`SELECT code FROM reference WHERE name = ?`, name).Scan(&taken)
+	if err == nil {
+		return ErrNameTaken
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	_, err = q.Exec(`INSERT INTO reference (code, name) VALUES (?, ?)`, code, name)
 	return err
 }
 
 func AddRef(code string, name string) error {
-
-	err := refSetupCodeName(code, name)
+	err := addRef(db, code, name)
 	if err != nil {
 		fmt.Print(err.Error(), code, name)
 		return err
 	}
 	return nil
 }
+
+// AddRefs names many codes in one transaction, such as the new listings of a
+// market day. Where AddRef would refuse a code, it is left out; only a
+// database error fails the whole.
+func AddRefs(names map[string]string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for code, name := range names {
+		err = addRef(tx, code, name)
+		if err == ErrNameTaken || IsValidationError(err) {
+			fmt.Printf("Skip ref %q -> %q: %s\n", code, name, err.Error())
+			continue
+		} else if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
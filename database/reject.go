@@ -0,0 +1,46 @@
+package myDatabase
+
+import (
+	"database/sql"
+)
+
+const REJECT_TABLE string = "rejects"
+
+const REJECT_COLUMNS = "id, date, market, code, row, reason"
+
+// Reject is a report row that could not be turned into a quote, kept so it
+// can be looked at and reloaded from the archive once the parser is fixed.
+type Reject struct {
+	Date   Date   `json:"date"`
+	Market string `json:"market"`
+	Code   string `json:"code"`
+	Row    string `json:"row"` // raw fields joined by '|'
+	Reason string `json:"reason"`
+}
+
+func genReject(rows *sql.Rows) (list []Reject, err error) {
+	for rows.Next() {
+		var r Reject
+		var Id int
+		err := rows.Scan(&Id, &r.Date, &r.Market, &r.Code, &r.Row, &r.Reason)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// PutRejects stores list, replacing stored rejects of the same date, market
+// and row. A reject's code may well be malformed.
+func PutRejects(list []Reject) error {
+	cmd := upsertCmd(REJECT_TABLE, []string{"date", "market", "row"}, "code", "reason")
+	return putRows(cmd, list, nil, func(r Reject) []any {
+		return []any{r.Date, r.Market, r.Row, r.Code, r.Reason}
+	})
+}
+
+// GetRejectRange returns the rejects within r, oldest first.
+func GetRejectRange(r DateRange) ([]Reject, error) {
+	return queryRange(scanDB, genReject, REJECT_TABLE, REJECT_COLUMNS, r, "")
+}
@@ -0,0 +1,61 @@
+package myDatabase
+
+import (
+	"database/sql"
+	"slices"
+)
+
+const REVENUE_TABLE string = "revenue"
+
+const REVENUE_COLUMNS = "id, date, code, revenue, mom, yoy"
+
+// Revenue is the revenue of one company in one month, dated the first day of
+// the month, in thousand NTD.
+type Revenue struct {
+	Month   Date    `json:"month"`
+	Code    string  `json:"code"`
+	Revenue int64   `json:"revenue"`
+	MoM     float64 `json:"mom"` // percent against the month before
+	YoY     float64 `json:"yoy"` // percent against the month a year before
+}
+
+func genRevenue(rows *sql.Rows) (list []Revenue, err error) {
+	for rows.Next() {
+		var r Revenue
+		var Id int
+		err := rows.Scan(&Id, &r.Month, &r.Code, &r.Revenue, &r.MoM, &r.YoY)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// PutRevenues stores list, replacing stored rows of the same month and code.
+func PutRevenues(list []Revenue) error {
+	cmd := upsertCmd(REVENUE_TABLE, []string{"date", "code"}, "revenue", "mom", "yoy")
+	return putRows(cmd, list, func(r Revenue) string { return r.Code }, func(r Revenue) []any {
+		return []any{r.Month, r.Code, r.Revenue, r.MoM, r.YoY}
+	})
+}
+
+// GetRevenue returns the last months of revenue of code, oldest first.
+func GetRevenue(code string, months int) ([]Revenue, error) {
+	if err := ValidateCode(code); err != nil {
+		return nil, err
+	}
+	cmd := "SELECT " + REVENUE_COLUMNS + " FROM " + REVENUE_TABLE +
+		" WHERE code = ?" +
+		" ORDER BY date DESC" +
+		" LIMIT ?"
+	rows, err := scanDB.Query(cmd, code, months)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list, err := genRevenue(rows)
+	slices.Reverse(list)
+	return list, err
+}
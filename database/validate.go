@@ -0,0 +1,71 @@
+package myDatabase
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Stock codes: four digits, optionally followed by up to two digits or
+// capital letters for ETFs, ETNs and preferred shares (0050, 00679B, 2881A).
+// Codes become part of stock table names, so nothing else may pass.
+var codePattern = regexp.MustCompile(`^[0-9]{4}[0-9A-Z]{0,2}$`)
+
+// ValidationError reports input rejected by the database layer. The HTTP
+// layer answers it with 400 instead of 500.
+type ValidationError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+func IsValidationError(err error) bool {
+	var verr *ValidationError
+	return errors.As(err, &verr)
+}
+
+func ValidateCode(code string) error {
+	if !codePattern.MatchString(code) {
+		return &ValidationError{Field: "code", Value: code, Reason: "not a stock code"}
+	}
+	return nil
+}
+
+// Code of the stock named tblName, stk<code>.
+func stockCode(tblName string) (string, error) {
+	code, found := strings.CutPrefix(tblName, STKPREFIX)
+	if !found || !codePattern.MatchString(code) {
+		return "", &ValidationError{Field: "table", Value: tblName, Reason: "not a stock table"}
+	}
+	return code, nil
+}
+
+// checkStockTblName accepts only stk<code> names.
+func checkStockTblName(tblName string) error {
+	_, err := stockCode(tblName)
+	return err
+}
+
+func (t Transaction) Validate() error {
+	if err := ValidateCode(t.Code); err != nil {
+		return err
+	}
+	if t.Date.IsZero() {
+		return &ValidationError{Field: "date", Value: "", Reason: "missing"}
+	}
+	if t.Price <= 0 {
+		return &ValidationError{Field: "price", Value: t.Price.String(), Reason: "must be positive"}
+	}
+	if t.Quantity <= 0 {
+		return &ValidationError{Field: "quantity", Value: fmt.Sprint(t.Quantity), Reason: "must be positive"}
+	}
+	if t.Fee < 0 {
+		return &ValidationError{Field: "fee", Value: fmt.Sprint(t.Fee), Reason: "must not be negative"}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package myDatabase
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const VALUATION_TABLE string = "valuation"
+
+var ErrNoValuation error = errors.New("no valuation")
+
+const VALUATION_COLUMNS = "id, date, code, pe, pb, yield"
+
+// Valuation metrics, each also the name of its column.
+const METRIC_PE = "pe"
+const METRIC_PB = "pb"
+const METRIC_YIELD = "yield"
+
+// Valuation is the daily valuation snapshot of one stock. A metric the
+// exchange leaves blank, such as the PE of a loss maker, is 0.
+type Valuation struct {
+	Date  Date    `json:"date"`
+	Code  string  `json:"code"`
+	PE    float64 `json:"pe"`
+	PB    float64 `json:"pb"`
+	Yield float64 `json:"yield"` // percent
+}
+
+// ValuationRank places the latest value of a metric within its own history.
+type ValuationRank struct {
+	Code       string  `json:"code"`
+	Metric     string  `json:"metric"`
+	Date       Date    `json:"date"`
+	Value      float64 `json:"value"`
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+	Samples    int     `json:"samples"`
+	Percentile float64 `json:"percentile"` // share of samples at or below Value, 0 to 100
+}
+
+func genValuation(rows *sql.Rows) (list []Valuation, err error) {
+	for rows.Next() {
+		var v Valuation
+		var Id int
+		err := rows.Scan(&Id, &v.Date, &v.Code, &v.PE, &v.PB, &v.Yield)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func checkMetric(metric string) error {
+	switch metric {
+	case METRIC_PE, METRIC_PB, METRIC_YIELD:
+		return nil
+	}
+	return &ValidationError{Field: "metric", Value: metric, Reason: "not pe, pb or yield"}
+}
+
+// PutValuations stores list, replacing stored rows of the same date and code.
+func PutValuations(list []Valuation) error {
+	cmd := upsertCmd(VALUATION_TABLE, []string{"date", "code"}, "pe", "pb", "yield")
+	return putRows(cmd, list, func(v Valuation) string { return v.Code }, func(v Valuation) []any {
+		return []any{v.Date, v.Code, v.PE, v.PB, v.Yield}
+	})
+}
+
+// GetValuationRange returns the snapshots of code within r, oldest first.
+func GetValuationRange(code string, r DateRange) ([]Valuation, error) {
+	if err := ValidateCode(code); err != nil {
+		return nil, err
+	}
+	return queryRange(scanDB, genValuation, VALUATION_TABLE, VALUATION_COLUMNS, r, "code = ?", code)
+}
+
+// RankValuation ranks the latest value of metric of code among its values
+// since since, blank values left out. ErrNoValuation means no latest value.
+func RankValuation(code string, metric string, since Date) (rank ValuationRank, err error) {
+	if err = ValidateCode(code); err != nil {
+		return rank, err
+	}
+	if err = checkMetric(metric); err != nil {
+		return rank, err
+	}
+	rank.Code = code
+	rank.Metric = metric
+
+	// The latest value and its rank within the window, in one read of the
+	// (code, date) index.
+	cmd := "WITH last AS (SELECT date, " + metric + " AS value FROM " + VALUATION_TABLE +
+		"	WHERE code = ? ORDER BY date DESC LIMIT 1)" +
+		" SELECT last.date, last.value, COUNT(v." + metric + "), IFNULL(MIN(v." + metric + "), 0)," +
+		" IFNULL(MAX(v." + metric + "), 0), IFNULL(SUM(v." + metric + " <= last.value), 0)" +
+		" FROM last LEFT JOIN " + VALUATION_TABLE + " v" +
+		" ON v.code = ? AND v." + metric + " > 0 AND v.date >= ?" +
+		" GROUP BY last.date, last.value"
+	var below int
+	err = scanDB.QueryRow(cmd, code, code, since).Scan(&rank.Date, &rank.Value, &rank.Samples, &rank.Min, &rank.Max, &below)
+	if err == sql.ErrNoRows || err == nil && rank.Value <= 0 {
+		return rank, ErrNoValuation
+	} else if err != nil {
+		return rank, fmt.Errorf("rank %s of %s: %w", metric, code, err)
+	}
+	if rank.Samples > 0 {
+		rank.Percentile = float64(below) * 100 / float64(rank.Samples)
+	}
+	return rank, nil
+}
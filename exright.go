@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+// Fetch log markets of the ex-rights reports.
+const MARKET_TWSE_EXRIGHT string = "twse-exright"
+const MARKET_TPEX_EXRIGHT string = "tpex-exright"
+
+// Columns are looked up by name since the two markets order them apart.
+// Rows without their own date are dated d.
+func parseExRights(body []byte, d mydb.Date) (list []mydb.ExRight, rejects []mydb.Reject, err error) {
+	tables, err := sideTables(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tbl := range tables {
+		idxDate := fieldIndex(tbl.Fields, "日期")
+		idxCode := fieldIndex(tbl.Fields, "代號")
+		idxPrev := fieldIndex(tbl.Fields, "前收盤價")
+		idxRef := fieldIndex(tbl.Fields, "除權息參考價")
+		idxKind := fieldIndex(tbl.Fields, "權/息")
+		if idxCode < 0 || idxPrev < 0 || idxRef < 0 || idxKind < 0 {
+			return nil, nil, fmt.Errorf("unknown ex-rights layout %q", tbl.Fields)
+		}
+		for _, data := range tbl.Data {
+			if len(data) < len(tbl.Fields) {
+				rejects = append(rejects, newReject(d, data, fmt.Errorf("short row of %d fields", len(data))))
+				continue
+			}
+			e := mydb.ExRight{Date: d, Code: strings.TrimSpace(data[idxCode])}
+			if isWarrant(e.Code) {
+				continue
+			}
+			if err = mydb.ValidateCode(e.Code); err != nil {
+				fmt.Printf("Skip row: %s\n", err.Error())
+				continue
+			}
+			if idxDate >= 0 {
+				if e.Date, err = parseROCDate(data[idxDate]); err != nil {
+					rejects = append(rejects, newReject(d, data, err))
+					continue
+				}
+			}
+			e.Kind = strings.TrimPrefix(strings.TrimSpace(data[idxKind]), "除")
+			var errPrev, errRef error
+			e.PrevClose, errPrev = mydb.ParsePrice(data[idxPrev])
+			e.RefPrice, errRef = mydb.ParsePrice(data[idxRef])
+			if errPrev != nil || errRef != nil {
+				fmt.Printf("Skip ex-rights of %s on %s without prices\n", e.Code, e.Date)
+				continue
+			}
+			list = append(list, e)
+		}
+	}
+	return list, rejects, nil
+}
+
+// Load the events of every market from from until today, for history older
+// than the fetch window.
+func loadExRightsSince(ctx context.Context, from mydb.Date) error {
+	to := lastReportDay()
+	for _, s := range sideReports {
+		if !s.ranged {
+			continue
+		}
+		rowNr, err := s.fetch(ctx, from, to)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.market, err)
+		}
+		fmt.Printf("Loaded %d %s events since %s\n", rowNr, s.market, from)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FETCH_BACKOFF_BASE time.Duration = 2 * time.Second
+const FETCH_BACKOFF_MAX time.Duration = time.Minute
+
+// The exchanges answer too frequent requests with an HTML page instead of
+// JSON, and keep blocking for a while. Back off at least this long then.
+const FETCH_BLOCK_BACKOFF time.Duration = 30 * time.Second
+
+// Phrases of the exchanges' block pages.
+var blockMarkers = []string{
+	"THE PAGE CANNOT BE ACCESSED",
+	"頁面無法執行",
+	"請求過於頻繁",
+	"Too Many Requests",
+}
+
+var ErrBlocked error = errors.New("blocked by exchange rate limit")
+var ErrNotJSON error = errors.New("response is not JSON")
+
+// Error worth another attempt after backing off.
+type retryableError struct {
+	err     error
+	backoff time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// tokenBucket lets one request through every interval, with bursts up to
+// burst requests after a quiet period.
+type tokenBucket struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(interval time.Duration, burst int) *tokenBucket {
+	return &tokenBucket{interval: interval, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Take a token, waiting for one if needed.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	if b.interval > 0 {
+		b.tokens = min(b.burst, b.tokens+float64(now.Sub(b.last))/float64(b.interval))
+	} else {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens * float64(b.interval))
+	}
+	b.mu.Unlock()
+
+	return sleepCtx(ctx, delay)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// exchangeClient fetches JSON reports from the exchanges politely: rate
+// limited per host, with timeouts and retries of transient failures.
+type exchangeClient struct {
+	client   *http.Client
+	interval time.Duration
+	retries  int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newExchangeClient(c Config) *exchangeClient {
+	return &exchangeClient{
+		client:   &http.Client{Timeout: time.Duration(c.FetchTimeoutSec * float64(time.Second))},
+		interval: time.Duration(c.FetchDelaySec * float64(time.Second)),
+		retries:  c.FetchRetries,
+		buckets:  map[string]*tokenBucket{},
+	}
+}
+
+func (c *exchangeClient) bucket(host string) *tokenBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, exist := c.buckets[host]
+	if !exist {
+		b = newTokenBucket(c.interval, 1)
+		c.buckets[host] = b
+	}
+	return b
+}
+
+// Full jitter: a random wait up to base doubled per attempt, capped.
+func backoff(attempt int) time.Duration {
+	d := FETCH_BACKOFF_MAX
+	if attempt < 16 {
+		d = min(FETCH_BACKOFF_BASE<<attempt, FETCH_BACKOFF_MAX)
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func isBlockPage(body []byte) bool {
+	for _, marker := range blockMarkers {
+		if strings.Contains(string(body), marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetJSON returns the body of a successful JSON response of rawURL.
+func (c *exchangeClient) GetJSON(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	b := c.bucket(u.Host)
+
+	for attempt := 0; ; attempt++ {
+		if err = b.wait(ctx); err != nil {
+			return nil, err
+		}
+		body, err := c.get(ctx, rawURL)
+		var rerr *retryableError
+		if !errors.As(err, &rerr) {
+			return body, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= c.retries {
+			return nil, rerr.err
+		}
+
+		wait := max(backoff(attempt), rerr.backoff)
+		fmt.Printf("Fetch %s failed (%s), retry in %s\n", rawURL, rerr.err.Error(), wait.Round(time.Millisecond))
+		if err = sleepCtx(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *exchangeClient) get(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &retryableError{err: err}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, &retryableError{err: ErrBlocked, backoff: FETCH_BLOCK_BACKOFF}
+	case resp.StatusCode >= 500:
+		return nil, &retryableError{err: fmt.Errorf("%w: %s", ErrFetchBad, resp.Status)}
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: %s", ErrFetchBad, resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/json" || mediaType == "text/json" {
+		return body, nil
+	}
+	// Some replies carry JSON as text/html; the body tells.
+	trimmed := strings.TrimSpace(string(body))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return body, nil
+	}
+	if isBlockPage(body) {
+		return nil, &retryableError{err: ErrBlocked, backoff: FETCH_BLOCK_BACKOFF}
+	}
+	return nil, &retryableError{err: fmt.Errorf("%w: %s", ErrNotJSON, mediaType)}
+}
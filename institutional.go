@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+// Fetch log markets of the institutional trades reports.
+const MARKET_TWSE_INST string = "twse-inst"
+const MARKET_TPEX_INST string = "tpex-inst"
+
+// Names of the net buy columns of one market's report. Foreign sums the
+// listed columns.
+type instColumns struct {
+	code    string
+	foreign []string
+	trust   string
+	dealer  string
+	total   string
+}
+
+var twseInstColumns = instColumns{
+	code:    "證券代號",
+	foreign: []string{"外陸資買賣超股數(不含外資自營商)", "外資自營商買賣超股數"},
+	trust:   "投信買賣超股數",
+	dealer:  "自營商買賣超股數",
+	total:   "三大法人買賣超股數",
+}
+
+var tpexInstColumns = instColumns{
+	code:    "代號",
+	foreign: []string{"外資及陸資-買賣超股數"},
+	trust:   "投信-買賣超股數",
+	dealer:  "自營商-買賣超股數",
+	total:   "三大法人買賣超股數合計",
+}
+
+func parseInst(body []byte, d mydb.Date, cols instColumns) (list []mydb.Institutional, rejects []mydb.Reject, err error) {
+	tables, err := sideTables(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tbl := range tables {
+		idxCode := fieldExact(tbl.Fields, cols.code)
+		idxTrust := fieldExact(tbl.Fields, cols.trust)
+		idxDealer := fieldExact(tbl.Fields, cols.dealer)
+		idxTotal := fieldExact(tbl.Fields, cols.total)
+		idxForeign := []int{}
+		for _, name := range cols.foreign {
+			idxForeign = append(idxForeign, fieldExact(tbl.Fields, name))
+		}
+		if idxCode < 0 || idxTrust < 0 || idxDealer < 0 || idxTotal < 0 || slices.Contains(idxForeign, -1) {
+			return nil, nil, fmt.Errorf("unknown institutional layout %q", tbl.Fields)
+		}
+	rows:
+		for _, data := range tbl.Data {
+			if len(data) < len(tbl.Fields) {
+				rejects = append(rejects, newReject(d, data, fmt.Errorf("short row of %d fields", len(data))))
+				continue
+			}
+			in := mydb.Institutional{Date: d, Code: strings.TrimSpace(data[idxCode])}
+			if isWarrant(in.Code) {
+				continue
+			}
+			if err = mydb.ValidateCode(in.Code); err != nil {
+				fmt.Printf("Skip row: %s\n", err.Error())
+				continue
+			}
+			nums := map[int]int64{}
+			for _, idx := range append([]int{idxTrust, idxDealer, idxTotal}, idxForeign...) {
+				n, err := parseNum(data[idx])
+				if err != nil {
+					rejects = append(rejects, newReject(d, data, err))
+					continue rows
+				}
+				nums[idx] = int64(n)
+			}
+			for _, idx := range idxForeign {
+				in.Foreign += nums[idx]
+			}
+			in.Trust = nums[idxTrust]
+			in.Dealer = nums[idxDealer]
+			in.Total = nums[idxTotal]
+			list = append(list, in)
+		}
+	}
+	return list, rejects, nil
+}
+
+func parseTWSEInst(body []byte, d mydb.Date) ([]mydb.Institutional, []mydb.Reject, error) {
+	return parseInst(body, d, twseInstColumns)
+}
+
+func parseTPExInst(body []byte, d mydb.Date) ([]mydb.Institutional, []mydb.Reject, error) {
+	return parseInst(body, d, tpexInstColumns)
+}
@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"math"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -36,6 +38,15 @@ func writeJSONErrResonse(w http.ResponseWriter, msg string, errcode int) {
 		"error": msg,
 	})
 }
+
+// Status code for an error from the database layer.
+func dbErrStatus(err error) int {
+	if mydb.IsValidationError(err) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func writeJSONParseIncomplete(w http.ResponseWriter, msg string, errcode int, data string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(errcode)
@@ -46,18 +57,56 @@ func writeJSONParseIncomplete(w http.ResponseWriter, msg string, errcode int, da
 }
 
 func main() {
-	mydb.InitMyDB()
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "list pending schema migrations and exit")
+	flag.Parse()
+
+	var err error
+	conf, err = loadConfig()
+	if err != nil {
+		log.Fatalf("Config: %v", err)
+	}
+	if *printConfig {
+		conf.Print()
+		return
+	}
+
+	if *migrateDryRun {
+		reportMigrations()
+		return
+	}
+	if runAdminCommand() {
+		return
+	}
+
+	mydb.InitMyDB(conf.DBConfig())
 	defer mydb.CloseMyDB()
 
-	server := &http.Server{Addr: ":8080"}
+	if err = loadCalendarFile(conf.CalendarFile); err != nil {
+		log.Fatalf("Calendar: %v", err)
+	}
+	learnCalendarFromQuotes()
+
+	server := &http.Server{Addr: conf.Addr}
 	http.HandleFunc("/", statisticHandler)
 	http.HandleFunc("/parseTrans", parseTransHandler)
 	http.HandleFunc("/addref", addRefHandler)
 	http.HandleFunc("/parser", parserHandler)
 	http.HandleFunc("/scanner", scannerHandler)
+	http.HandleFunc("/admin/backup", adminHandler)
+	http.HandleFunc("/fetch/status", fetchStatusHandler)
+	http.HandleFunc("/backfill", backfillHandler)
+	http.HandleFunc("/valuation", valuationHandler)
+
+	exClient = newExchangeClient(conf)
+	quoteProviders = newLiveProviders(exClient)
+	sideReports = newSideReports(exClient)
+	revenueReports = newRevenueReports(exClient)
+	var stopJobs context.CancelFunc
+	appCtx, stopJobs = context.WithCancel(context.Background())
+	go scheduler.Run(appCtx)
 
 	go func() {
-		fmt.Println("Server started at http://localhost:8080")
+		fmt.Printf("Server started at %s\n", conf.Addr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("ListenAndServe error: %v\n", err)
 		}
@@ -68,6 +117,8 @@ func main() {
 
 	<-quit
 	fmt.Println("\nShutting down server...")
+	stopJobs()
+	scheduler.Wait()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -77,12 +128,32 @@ func main() {
 	} else {
 		fmt.Println("Server exited properly")
 	}
+	// No backfill starts once the server is down; wait for the cancelled ones.
+	backfillWG.Wait()
+}
+
+func reportMigrations() {
+	mydb.OpenMyDBReadOnly(conf.DBConfig())
+	defer mydb.CloseMyDB()
+
+	list, err := mydb.PendingMigrations()
+	if err != nil {
+		fmt.Printf("Failed to check migrations: %v\n", err)
+		return
+	}
+	if len(list) == 0 {
+		fmt.Println("No pending migration.")
+		return
+	}
+	for _, m := range list {
+		fmt.Printf("Pending: %s database version %d (%s)\n", m.DB, m.Version, m.Name)
+	}
 }
 
 func parserHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		http.ServeFile(w, r, "./web/account.html")
+		http.ServeFile(w, r, filepath.Join(conf.WebDir, "account.html"))
 	case "POST":
 		createTransaction(w, r)
 	default:
@@ -109,7 +180,10 @@ func addRefHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		code := textContent.C1
 		name := textContent.C2
-		mydb.AddRef(code, name)
+		if err := mydb.AddRef(code, name); err != nil {
+			writeJSONErrResonse(w, err.Error(), dbErrStatus(err))
+			return
+		}
 		writeJSONOKResonse(w, textContent)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -119,7 +193,7 @@ func addRefHandler(w http.ResponseWriter, r *http.Request) {
 func statisticHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		http.ServeFile(w, r, "./web/index.html")
+		http.ServeFile(w, r, filepath.Join(conf.WebDir, "index.html"))
 	case "POST":
 		doStatistic(w, r)
 	default:
@@ -130,7 +204,7 @@ func statisticHandler(w http.ResponseWriter, r *http.Request) {
 func scannerHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		http.ServeFile(w, r, "./web/scanner.html")
+		http.ServeFile(w, r, filepath.Join(conf.WebDir, "scanner.html"))
 	case "POST":
 		doScan(w, r)
 	default:
@@ -145,18 +219,11 @@ func createTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t.Total = int(math.Round(t.Price * float64(t.Quantity)))
-	if t.Direction {
-		t.Tax = 0
-		t.Net = t.Total + t.Fee
-	} else {
-		t.Tax = int(math.Round(float64(t.Total) * 0.003))
-		t.Net = t.Total - t.Fee - t.Tax
-	}
+	t = mydb.CreateTransaction(t.Date, t.Direction, t.Code, t.Price, t.Quantity, t.Fee)
 
-	err := mydb.AddTransaction(t)
+	err := recordTrade(t)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), dbErrStatus(err))
 		return
 	}
 
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+// Fetch log markets of the margin balance reports.
+const MARKET_TWSE_MARGIN string = "twse-margin"
+const MARKET_TPEX_MARGIN string = "tpex-margin"
+
+// A column named name, the nth of that name.
+type namedColumn struct {
+	name string
+	nth  int
+}
+
+func (c namedColumn) index(fields []string) int {
+	return fieldNth(fields, c.name, c.nth)
+}
+
+// Columns of one market's margin report, in lots.
+type marginColumns struct {
+	code        namedColumn
+	margin      namedColumn
+	marginLimit namedColumn
+	short       namedColumn
+	shortLimit  namedColumn
+}
+
+// TWSE groups the columns under 融資 and 融券, repeating their names.
+var twseMarginColumns = marginColumns{
+	code:        namedColumn{"代號", 0},
+	margin:      namedColumn{"今日餘額", 0},
+	marginLimit: namedColumn{"限額", 0},
+	short:       namedColumn{"今日餘額", 1},
+	shortLimit:  namedColumn{"限額", 1},
+}
+
+var tpexMarginColumns = marginColumns{
+	code:        namedColumn{"代號", 0},
+	margin:      namedColumn{"資餘額", 0},
+	marginLimit: namedColumn{"資限額", 0},
+	short:       namedColumn{"券餘額", 0},
+	shortLimit:  namedColumn{"券限額", 0},
+}
+
+// Tables without a code column, such as the market summary, are skipped.
+func parseMargin(body []byte, d mydb.Date, cols marginColumns) (list []mydb.Margin, rejects []mydb.Reject, err error) {
+	tables, err := sideTables(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tbl := range tables {
+		idxCode := cols.code.index(tbl.Fields)
+		if idxCode < 0 {
+			continue
+		}
+		idx := []int{
+			cols.margin.index(tbl.Fields),
+			cols.marginLimit.index(tbl.Fields),
+			cols.short.index(tbl.Fields),
+			cols.shortLimit.index(tbl.Fields),
+		}
+		for _, i := range idx {
+			if i < 0 {
+				return nil, nil, fmt.Errorf("unknown margin layout %q", tbl.Fields)
+			}
+		}
+	rows:
+		for _, data := range tbl.Data {
+			if len(data) < len(tbl.Fields) {
+				rejects = append(rejects, newReject(d, data, fmt.Errorf("short row of %d fields", len(data))))
+				continue
+			}
+			m := mydb.Margin{Date: d, Code: strings.TrimSpace(data[idxCode])}
+			if isWarrant(m.Code) {
+				continue
+			}
+			if err = mydb.ValidateCode(m.Code); err != nil {
+				fmt.Printf("Skip row: %s\n", err.Error())
+				continue
+			}
+			var nums [4]int64
+			for i, j := range idx {
+				n, err := parseNum(data[j])
+				if err != nil {
+					rejects = append(rejects, newReject(d, data, err))
+					continue rows
+				}
+				nums[i] = int64(n)
+			}
+			m.Margin, m.MarginLimit, m.Short, m.ShortLimit = nums[0], nums[1], nums[2], nums[3]
+			list = append(list, m)
+		}
+	}
+	return list, rejects, nil
+}
+
+func parseTWSEMargin(body []byte, d mydb.Date) ([]mydb.Margin, []mydb.Reject, error) {
+	return parseMargin(body, d, twseMarginColumns)
+}
+
+func parseTPExMargin(body []byte, d mydb.Date) ([]mydb.Margin, []mydb.Reject, error) {
+	return parseMargin(body, d, tpexMarginColumns)
+}
@@ -43,8 +43,8 @@ func parseEntry(entry string) (errcode int, msg string) {
 		return http.StatusBadRequest, msg
 	}
 
-	// Price: Convert to float64
-	price, err := strconv.ParseFloat(strings.Replace(pricePart, ",", "", -1), 64)
+	// Price: Convert to fixed-point
+	price, err := mydb.ParsePrice(pricePart)
 	if err != nil {
 		return http.StatusBadRequest, "Invalid price format"
 	}
@@ -56,22 +56,17 @@ func parseEntry(entry string) (errcode int, msg string) {
 	}
 
 	// Fee: Convert to int
-	fee, err := strconv.Atoi(strings.Replace(feePart, ",", "", -1))
+	fee, err := strconv.ParseInt(strings.Replace(feePart, ",", "", -1), 10, 64)
 	if err != nil {
 		return http.StatusBadRequest, "Invalid fee format"
 	}
 
 	// Create Transaction instance
-	transaction := mydb.CreateTransaction(y, int(m), d, direction, code, price, quantity, fee)
+	transaction := mydb.CreateTransaction(mydb.NewDate(y, int(m), d), direction, code, price, quantity, mydb.Money(fee))
 
-	err = mydb.AddTransaction(transaction)
+	err = recordTrade(transaction)
 	if err != nil {
-		return http.StatusInternalServerError, err.Error()
-	}
-
-	err = procTrans(transaction)
-	if err != nil {
-		return http.StatusInternalServerError, err.Error()
+		return dbErrStatus(err), err.Error()
 	}
 
 	return http.StatusOK, ""
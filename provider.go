@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+// Bar is the normalized daily bar of one stock, whatever market it came from.
+type Bar struct {
+	Code  string
+	Name  string
+	Quote mydb.DaliyQuote
+}
+
+// DayReport is what one daily report of a market yields: the bars, the number
+// of rows left out on purpose such as warrants, and the rows that could not be
+// read.
+type DayReport struct {
+	Bars    []Bar
+	Skipped int
+	Rejects []mydb.Reject
+}
+
+// QuoteProvider yields the daily bars of one market. DailyBars returns
+// ErrNoEntry when the market published no report for d.
+type QuoteProvider interface {
+	Market() string
+	DailyBars(ctx context.Context, d mydb.Date) (DayReport, error)
+}
+
+// Turns one raw report of a market into bars. A bad row is rejected rather
+// than failing the report.
+type reportParser func(body []byte, d mydb.Date) (DayReport, error)
+
+var reportParsers = map[string]reportParser{
+	MARKET_TWSE: parseTWSE,
+	MARKET_TPEX: parseTPEx,
+}
+
+// liveProvider downloads reports from the exchange, and archives them before
+// parsing so that a misparse can be redone later.
+type liveProvider struct {
+	market     string
+	urlFmt     string // formatted with year, month, day
+	client     *exchangeClient
+	parse      reportParser
+	archiveDir string
+}
+
+func newTWSEProvider(client *exchangeClient) QuoteProvider {
+	return &liveProvider{market: MARKET_TWSE, urlFmt: conf.TWSEURL, client: client, parse: parseTWSE, archiveDir: conf.ArchiveDir}
+}
+
+func newTPExProvider(client *exchangeClient) QuoteProvider {
+	return &liveProvider{market: MARKET_TPEX, urlFmt: conf.TPExURL, client: client, parse: parseTPEx, archiveDir: conf.ArchiveDir}
+}
+
+func (p *liveProvider) Market() string {
+	return p.market
+}
+
+func (p *liveProvider) DailyBars(ctx context.Context, d mydb.Date) (DayReport, error) {
+	y, m, day := d.Date()
+	url := fmt.Sprintf(p.urlFmt, y, int(m), day)
+	fmt.Printf("Fetching %s (%s)...\n", url, d)
+
+	body, err := p.client.GetJSON(ctx, url)
+	if err != nil {
+		fmt.Printf("Fetch failed = %s\n", err.Error())
+		return DayReport{}, err
+	}
+	if err = archiveReport(p.archiveDir, p.market, d, body); err != nil {
+		fmt.Printf("Failed to archive %s %s: %s\n", p.market, d, err.Error())
+	}
+	return p.parse(body, d)
+}
+
+// fileProvider reads recorded reports, one file per day, from
+// <dir>/<market>/<YYYY-MM-DD>.json, or the gzipped .json.gz the archive
+// keeps. A missing file means no report.
+type fileProvider struct {
+	market string
+	dir    string
+	parse  reportParser
+}
+
+func newFileProvider(dir string, market string) (*fileProvider, error) {
+	parse, exist := reportParsers[market]
+	if !exist {
+		return nil, fmt.Errorf("no parser for market %q", market)
+	}
+	return &fileProvider{market: market, dir: filepath.Join(dir, market), parse: parse}, nil
+}
+
+func (p *fileProvider) Market() string {
+	return p.market
+}
+
+func (p *fileProvider) DailyBars(ctx context.Context, d mydb.Date) (DayReport, error) {
+	body, err := os.ReadFile(filepath.Join(p.dir, d.String()+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		body, err = readArchived(filepath.Join(p.dir, d.String()+ARCHIVE_SUFFIX))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return DayReport{}, ErrNoEntry
+	} else if err != nil {
+		return DayReport{}, err
+	}
+	return p.parse(body, d)
+}
+
+// Days with a recorded report, oldest first.
+func (p *fileProvider) Dates() ([]mydb.Date, error) {
+	entries, err := os.ReadDir(p.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	days := []mydb.Date{}
+	for _, ent := range entries {
+		name, found := strings.CutSuffix(ent.Name(), ARCHIVE_SUFFIX)
+		if !found {
+			name, found = strings.CutSuffix(ent.Name(), ".json")
+		}
+		if !found || ent.IsDir() {
+			continue
+		}
+		d, err := mydb.ParseDate(name)
+		if err != nil {
+			continue
+		}
+		// Names sort by date, so both copies of a day are adjacent.
+		if len(days) > 0 && days[len(days)-1].Equal(d) {
+			continue
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+// Live providers of every market, in fetch order. TWSE goes first: its
+// missing report is what tells a closed day.
+func newLiveProviders(client *exchangeClient) []QuoteProvider {
+	return []QuoteProvider{newTWSEProvider(client), newTPExProvider(client)}
+}
+
+// Load every recorded report under dir into the daily database, replacing
+// stored bars of the same days.
+func rebuildFromDir(ctx context.Context, dir string) error {
+	for _, market := range []string{MARKET_TWSE, MARKET_TPEX} {
+		p, err := newFileProvider(dir, market)
+		if err != nil {
+			return err
+		}
+		days, err := p.Dates()
+		if err != nil {
+			return err
+		}
+		var sum FetchSummary
+		for _, d := range days {
+			daySum, err := fetchDay(ctx, p, d, true)
+			sum.Add(daySum)
+			if err == ErrNoEntry {
+				mydb.RecordFetch(d, market, mydb.FETCH_CLOSED, 0, nil)
+				continue
+			} else if err != nil {
+				mydb.RecordFetch(d, market, mydb.FETCH_FAILED, daySum.Stored, err)
+				return fmt.Errorf("%s %s: %w", market, d, err)
+			}
+			if err = mydb.RecordFetch(d, market, mydb.FETCH_OK, daySum.Stored, nil); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Loaded %d %s days from %s: %d stored, %d skipped, %d rejected\n",
+			len(days), market, dir, sum.Stored, sum.Skipped, sum.Rejected)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+// Fetch log markets of the monthly revenue reports.
+const MARKET_TWSE_REVENUE string = "twse-revenue"
+const MARKET_TPEX_REVENUE string = "tpex-revenue"
+
+// Least consecutive months of the revenue screen by default.
+const DEFAULT_GROWTH_MONTHS int = 3
+
+// Created at startup from the config. Companies publish through the first
+// days of a month, so the latest reports are polled once a day.
+var revenueReports []*sideReport
+
+func newRevenueReports(client *exchangeClient) []*sideReport {
+	return []*sideReport{
+		{MARKET_TWSE_REVENUE, conf.TWSERevenueURL, false, true, client, conf.ArchiveDir, loadWith(parseRevenue, mydb.PutRevenues)},
+		{MARKET_TPEX_REVENUE, conf.TPExRevenueURL, false, true, client, conf.ArchiveDir, loadWith(parseRevenue, mydb.PutRevenues)},
+	}
+}
+
+// ROC year and month like 11309.
+func parseROCMonth(s string) (mydb.Date, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 4 {
+		return mydb.Date{}, fmt.Errorf("invalid ROC month %q", s)
+	}
+	y, err1 := strconv.Atoi(s[:len(s)-2])
+	m, err2 := strconv.Atoi(s[len(s)-2:])
+	if err1 != nil || err2 != nil || m < 1 || m > 12 {
+		return mydb.Date{}, fmt.Errorf("invalid ROC month %q", s)
+	}
+	return mydb.NewDate(y+1911, m, 1), nil
+}
+
+// Change from prev to cur in percent, 0 without prev.
+func growth(cur int64, prev int64) float64 {
+	if prev <= 0 {
+		return 0
+	}
+	return float64(cur-prev) * 100 / float64(prev)
+}
+
+// The open data reports are lists of records keyed by column name, each
+// dated by its own month rather than d.
+func parseRevenue(body []byte, d mydb.Date) (list []mydb.Revenue, rejects []mydb.Reject, err error) {
+	var records []map[string]string
+	if err = json.Unmarshal(body, &records); err != nil {
+		return nil, nil, err
+	}
+	keys := []string{"公司代號", "資料年月", "營業收入-當月營收", "營業收入-上月營收", "營業收入-去年當月營收"}
+records:
+	for _, rec := range records {
+		r := mydb.Revenue{Code: strings.TrimSpace(rec["公司代號"])}
+		if err = mydb.ValidateCode(r.Code); err != nil {
+			fmt.Printf("Skip row: %s\n", err.Error())
+			continue
+		}
+		data := make([]string, len(keys))
+		for i, key := range keys {
+			data[i] = rec[key]
+		}
+		if r.Month, err = parseROCMonth(rec["資料年月"]); err != nil {
+			rejects = append(rejects, newReject(d, data, err))
+			continue
+		}
+		var nums [3]float64
+		for i, key := range keys[2:] {
+			if nums[i], err = parseNum(rec[key]); err != nil {
+				rejects = append(rejects, newReject(d, data, fmt.Errorf("%s: %w", key, err)))
+				continue records
+			}
+		}
+		r.Revenue = int64(nums[0])
+		r.MoM = growth(r.Revenue, int64(nums[1]))
+		r.YoY = growth(r.Revenue, int64(nums[2]))
+		list = append(list, r)
+	}
+	return list, rejects, nil
+}
+
+// Poll the latest revenue reports, once a day, recorded under the last
+// report day. A failed report is logged and retried on the next run; only a
+// shutdown or a database error stops the fetch.
+func updateRevenue(ctx context.Context, logs map[string]mydb.FetchLog) error {
+	day := lastReportDay()
+	for _, s := range revenueReports {
+		if !needFetch(logs, day, s.market) {
+			continue
+		}
+		rowNr, err := s.fetch(ctx, day, day)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Left for the next run, like the side reports.
+			fmt.Printf("Fetch %s %s failed: %s\n", s.market, day, err.Error())
+			mydb.RecordFetch(day, s.market, mydb.FETCH_FAILED, rowNr, err)
+			continue
+		}
+		if err = mydb.RecordFetch(day, s.market, mydb.FETCH_OK, rowNr, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Companies whose revenue grew at least option percent year over year in
+// each of the last months months, in a row.
+func findRevenueGrowth(option string, months int, tblName string, interval int, dqs []mydb.DaliyQuote, revenues []mydb.Revenue) (Result, error) {
+	least := 0.0
+	if option != "" {
+		var err error
+		if least, err = strconv.ParseFloat(option, 64); err != nil {
+			return Result{}, fmt.Errorf("invalid growth %q", option)
+		}
+	}
+	if months <= 0 {
+		months = DEFAULT_GROWTH_MONTHS
+	}
+
+	totalLen := len(dqs)
+	if totalLen < interval+conf.BaseQDsNr {
+		return Result{}, ErrTooFewDays
+	}
+	if len(revenues) < months {
+		return Result{}, ErrNotInsterested
+	}
+	recent := revenues[len(revenues)-months:]
+	// A stale latest month means the company stopped reporting.
+	y, m, _ := dqs[totalLen-1].Date.Date()
+	if recent[months-1].Month.Before(mydb.NewDate(y, int(m)-2, 1)) {
+		return Result{}, ErrNotInsterested
+	}
+	yoys := []string{}
+	for i, r := range recent {
+		if r.YoY < least {
+			return Result{}, ErrNotInsterested
+		}
+		if i > 0 && !recent[i-1].Month.Time.AddDate(0, 1, 0).Equal(r.Month.Time) {
+			return Result{}, ErrNotInsterested
+		}
+		yoys = append(yoys, fmt.Sprintf("%+.0f%%", r.YoY))
+	}
+
+	config := genTrendChart(tblName, dqs)
+	info := "YoY " + strings.Join(yoys, " ")
+	return Result{Config: config, Info: info}, nil
+}
@@ -10,7 +10,7 @@ import (
 func findGapCallClose(foundDay int, dqs []mydb.DaliyQuote, ma5 []DataPoint, ma10 []DataPoint, ma20 []DataPoint) int {
 	base := dqs[foundDay-1].High
 	for i := foundDay + 1; i < len(dqs); i = i + 1 {
-		maDay := i - BASE_QDS_NR
+		maDay := i - conf.BaseQDsNr
 		avg5 := ma5[maDay].Y
 		avg10 := ma10[maDay].Y
 		avg20 := ma20[maDay].Y
@@ -19,9 +19,10 @@ func findGapCallClose(foundDay int, dqs []mydb.DaliyQuote, ma5 []DataPoint, ma10
 			return i
 		}
 
-		fail5 := dqs[i].Close <= avg5
-		fail10 := dqs[i].Close <= avg10
-		fail20 := dqs[i].Close <= avg20
+		close := dqs[i].Close.Float64()
+		fail5 := close <= avg5
+		fail10 := close <= avg10
+		fail20 := close <= avg20
 
 		if fail5 && fail10 && fail20 {
 			return i
@@ -33,7 +34,7 @@ func findGapCallClose(foundDay int, dqs []mydb.DaliyQuote, ma5 []DataPoint, ma10
 func findGapPutClose(foundDay int, dqs []mydb.DaliyQuote, ma5 []DataPoint, ma10 []DataPoint, ma20 []DataPoint) int {
 	base := dqs[foundDay-1].Low
 	for i := foundDay + 1; i < len(dqs); i = i + 1 {
-		maDay := i - BASE_QDS_NR
+		maDay := i - conf.BaseQDsNr
 		avg5 := ma5[maDay].Y
 		avg10 := ma10[maDay].Y
 		avg20 := ma20[maDay].Y
@@ -43,9 +44,10 @@ func findGapPutClose(foundDay int, dqs []mydb.DaliyQuote, ma5 []DataPoint, ma10
 			return i
 		}
 
-		fail5 := dqs[i].Close >= avg5
-		fail10 := dqs[i].Close >= avg10
-		fail20 := dqs[i].Close >= avg20
+		close := dqs[i].Close.Float64()
+		fail5 := close >= avg5
+		fail10 := close >= avg10
+		fail20 := close >= avg20
 
 		if fail5 && fail10 && fail20 {
 			// fmt.Printf("close=%f ma=%f,%f,%f\n", dqs[i].Close, avg5, avg10, avg20)
@@ -55,9 +57,9 @@ func findGapPutClose(foundDay int, dqs []mydb.DaliyQuote, ma5 []DataPoint, ma10
 	return -1
 }
 
-func genLineDP(dq *mydb.DaliyQuote, dqEnd *mydb.DaliyQuote, y float64) []DataPoint {
-	a := DataPoint{X: toMMDD(dq), Y: y}
-	b := DataPoint{X: toMMDD(dqEnd), Y: y}
+func genLineDP(dq *mydb.DaliyQuote, dqEnd *mydb.DaliyQuote, p mydb.Price) []DataPoint {
+	a := DataPoint{X: toMMDD(dq), Y: p.Float64()}
+	b := DataPoint{X: toMMDD(dqEnd), Y: p.Float64()}
 	return []DataPoint{a, b}
 }
 
@@ -66,14 +68,14 @@ func findGap(option string, tblName string, interval int, dqs []mydb.DaliyQuote)
 	const FIND_TYP_CALL = 1
 	const FIND_TYP_PUT = 2
 	findType := 0
-	day := BASE_QDS_NR
+	day := conf.BaseQDsNr
 	totalLen := len(dqs)
-	if len(dqs) < BASE_QDS_NR+interval {
-		fmt.Printf("ERR: %s day count is %d. It should over %d\n", tblName, len(dqs), BASE_QDS_NR+interval)
+	if len(dqs) < conf.BaseQDsNr+interval {
+		fmt.Printf("ERR: %s day count is %d. It should over %d\n", tblName, len(dqs), conf.BaseQDsNr+interval)
 		return Result{}, ErrTooFewDays
 	}
 	skipDay := totalLen - interval/2
-	if dqs[totalLen-1].Volume < MIN_INTRESTED_VOL {
+	if dqs[totalLen-1].Volume < conf.MinInterestedVol {
 		return Result{}, ErrNotInsterested
 	}
 	if option == "Call" {
@@ -86,7 +88,7 @@ func findGap(option string, tblName string, interval int, dqs []mydb.DaliyQuote)
 
 	findings := 0
 	foundDay := -1
-	foundGap := 0.0
+	var foundGap mydb.Price = 0
 	labels := []string{}
 	vols := []DataPoint{}
 	candle := []DataPoint{}
@@ -94,7 +96,7 @@ func findGap(option string, tblName string, interval int, dqs []mydb.DaliyQuote)
 	hline2 := Dataset{}
 
 	for day < skipDay {
-		mmdd := fmt.Sprintf("%02d%02d", dqs[day].Month, dqs[day].Day)
+		mmdd := toMMDD(&dqs[day])
 		candle = append(candle, toCandleDataPoint(mmdd, &dqs[day]))
 		vols = append(vols, toVolumeDataPoint(mmdd, &dqs[day]))
 		labels = append(labels, toMMDD(&dqs[day]))
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+const DEFAULT_STREAK_DAYS int = 3
+
+// Net buy of the named group; trust when not named.
+func instNet(group string) (func(in *mydb.Institutional) int64, error) {
+	switch group {
+	case "foreign":
+		return func(in *mydb.Institutional) int64 { return in.Foreign }, nil
+	case "trust", "":
+		return func(in *mydb.Institutional) int64 { return in.Trust }, nil
+	case "dealer":
+		return func(in *mydb.Institutional) int64 { return in.Dealer }, nil
+	case "total":
+		return func(in *mydb.Institutional) int64 { return in.Total }, nil
+	}
+	return nil, fmt.Errorf("no such institutional group %q", group)
+}
+
+// Institutional flows of the stock over the days of dqs.
+func getFlows(tblName string, dqs []mydb.DaliyQuote) ([]mydb.Institutional, error) {
+	if len(dqs) == 0 {
+		return nil, nil
+	}
+	code, _ := strings.CutPrefix(tblName, mydb.STKPREFIX)
+	return mydb.GetInstitutionalRange(code, mydb.DateRange{From: dqs[0].Date})
+}
+
+// Institutional flows as chart points in lots.
+func toFlowDataPoints(flows []mydb.Institutional, net func(in *mydb.Institutional) int64) []DataPoint {
+	points := []DataPoint{}
+	for i := range flows {
+		points = append(points, GenXYDataPoint(flows[i].Date.Format("0102"), float64(net(&flows[i])/1000)))
+	}
+	return points
+}
+
+// Show the foreign and trust flows of the charted days under a result.
+func addFlowDatasets(result *Result, flows []mydb.Institutional) {
+	config, ok := result.Config.(ChartConfig)
+	if !ok || len(flows) == 0 {
+		return
+	}
+	shown := map[string]bool{}
+	for _, label := range config.Data.Labels {
+		shown[label] = true
+	}
+	inChart := []mydb.Institutional{}
+	for _, in := range flows {
+		if shown[in.Date.Format("0102")] {
+			inChart = append(inChart, in)
+		}
+	}
+	foreign, _ := instNet("foreign")
+	trust, _ := instNet("trust")
+	AddAxisDatasets(&config, "flow", "Net Buy",
+		GenFlowDataset("Foreign", toFlowDataPoints(inChart, foreign), FOREIGN_ORANGE),
+		GenFlowDataset("Trust", toFlowDataPoints(inChart, trust), TRUST_RED))
+	result.Config = config
+}
+
+// Stocks the group has net bought on at least minDays trading days in a row,
+// up to the last quote.
+func findInstStreak(group string, minDays int, tblName string, interval int, dqs []mydb.DaliyQuote, flows []mydb.Institutional) (Result, error) {
+	net, err := instNet(group)
+	if err != nil {
+		return Result{}, err
+	}
+	if minDays <= 0 {
+		minDays = DEFAULT_STREAK_DAYS
+	}
+
+	totalLen := len(dqs)
+	if totalLen < interval+conf.BaseQDsNr {
+		return Result{}, ErrTooFewDays
+	}
+	if dqs[totalLen-1].Volume < conf.MinInterestedVol {
+		return Result{}, ErrNotInsterested
+	}
+
+	// Walk the quote days back. Every trading day has a quote row, traded or
+	// not, so a day the stock is missing from the report, or whose report
+	// failed, ends the streak.
+	byDate := map[string]*mydb.Institutional{}
+	for i := range flows {
+		byDate[flows[i].Date.String()] = &flows[i]
+	}
+	streak := 0
+	var sum int64 = 0
+	for i := totalLen - 1; i >= 0; i -= 1 {
+		in, exist := byDate[dqs[i].Date.String()]
+		if !exist || net(in) <= 0 {
+			break
+		}
+		streak += 1
+		sum += net(in)
+	}
+	if streak < minDays {
+		return Result{}, ErrNotInsterested
+	}
+
+	config := genTrendChart(tblName, dqs)
+	if group == "" {
+		group = "trust"
+	}
+	info := fmt.Sprintf("%s %d days +%s", group, streak, toHumanized(sum/1000))
+	return Result{Config: config, Info: info}, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	mydb "myDatabase"
+)
+
+// Least short-to-margin ratio of short-ratio, in percent.
+const DEFAULT_SHORT_RATIO float64 = 30
+
+// Show the margin and short balances of the charted days under a result.
+func addMarginDatasets(result *Result, margins []mydb.Margin) {
+	config, ok := result.Config.(ChartConfig)
+	if !ok || len(margins) == 0 {
+		return
+	}
+	shown := map[string]bool{}
+	for _, label := range config.Data.Labels {
+		shown[label] = true
+	}
+	margin := []DataPoint{}
+	short := []DataPoint{}
+	for _, m := range margins {
+		mmdd := m.Date.Format("0102")
+		if !shown[mmdd] {
+			continue
+		}
+		margin = append(margin, GenXYDataPoint(mmdd, float64(m.Margin)))
+		short = append(short, GenXYDataPoint(mmdd, float64(m.Short)))
+	}
+	AddAxisDatasets(&config, "margin", "Balance",
+		GenBalanceDataset("Margin", margin, MARGIN_GREEN),
+		GenBalanceDataset("Short", short, SHORT_GREY))
+	result.Config = config
+}
+
+// Stocks whose short balance on the last quote day is at least option
+// percent of the margin balance (券資比).
+func findShortRatio(option string, tblName string, interval int, dqs []mydb.DaliyQuote, margins []mydb.Margin) (Result, error) {
+	least := DEFAULT_SHORT_RATIO
+	if option != "" {
+		var err error
+		if least, err = strconv.ParseFloat(option, 64); err != nil {
+			return Result{}, fmt.Errorf("invalid short ratio %q", option)
+		}
+	}
+
+	totalLen := len(dqs)
+	if totalLen < interval+conf.BaseQDsNr {
+		return Result{}, ErrTooFewDays
+	}
+	if dqs[totalLen-1].Volume < conf.MinInterestedVol {
+		return Result{}, ErrNotInsterested
+	}
+	if len(margins) == 0 || !margins[len(margins)-1].Date.Equal(dqs[totalLen-1].Date) {
+		return Result{}, ErrNotInsterested
+	}
+	last := margins[len(margins)-1]
+	if last.Margin == 0 || last.ShortMarginRatio()*100 < least {
+		return Result{}, ErrNotInsterested
+	}
+
+	config := genTrendChart(tblName, dqs)
+	info := fmt.Sprintf("short/margin=%.1f%% margin used=%.1f%%", last.ShortMarginRatio()*100, last.MarginUsage()*100)
+	return Result{Config: config, Info: info}, nil
+}
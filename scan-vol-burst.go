@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"strings"
 
 	mydb "myDatabase"
 )
@@ -37,11 +36,11 @@ func findVolBurst(tblName string, interval int, dqs []mydb.DaliyQuote) (Result,
 	const BURST_MUL int64 = 3
 
 	totalLen := len(dqs)
-	if totalLen < interval+BASE_QDS_NR {
-		// fmt.Printf("ERR: %s day count is %d it should be %d\n", tblName, len(dqs), interval+BASE_QDS_NR)
+	if totalLen < interval+conf.BaseQDsNr {
+		// fmt.Printf("ERR: %s day count is %d it should be %d\n", tblName, len(dqs), interval+conf.BaseQDsNr)
 		return Result{}, ErrTooFewDays
 	}
-	if dqs[totalLen-1].Volume < MIN_INTRESTED_VOL {
+	if dqs[totalLen-1].Volume < conf.MinInterestedVol {
 		return Result{}, ErrNotInsterested
 	}
 
@@ -53,42 +52,20 @@ func findVolBurst(tblName string, interval int, dqs []mydb.DaliyQuote) (Result,
 		}
 	}
 
-	candles := []DataPoint{}
-	volumes := []DataPoint{}
-	labels := []string{}
 	var avg int64 = 0
 	var nr int64 = 0
-	for i := BASE_QDS_NR; i < totalLen-1; i += 1 {
-		weight := int64(i - BASE_QDS_NR - 1)
+	for i := conf.BaseQDsNr; i < totalLen-1; i += 1 {
+		weight := int64(i - conf.BaseQDsNr - 1)
 		avg += (dqs[i].Volume * weight)
 		nr += weight
-		mmdd := toMMDD(&dqs[i])
-		candles = append(candles, toCandleDataPoint(mmdd, &dqs[i]))
-		volumes = append(volumes, toVolumeDataPoint(mmdd, &dqs[i]))
-		labels = append(labels, mmdd)
 	}
 	avg /= nr
-	mmdd := toMMDD(&dqs[totalLen-1])
-	candles = append(candles, toCandleDataPoint(mmdd, &dqs[totalLen-1]))
-	volumes = append(volumes, toVolumeDataPoint(mmdd, &dqs[totalLen-1]))
-	labels = append(labels, mmdd)
 
 	if avg*BURST_MUL >= dqs[totalLen-1].Volume {
 		return Result{}, ErrNotInsterested
 	}
 
-	ma5 := genMA(dqs, 5)
-	ma10 := genMA(dqs, 10)
-	ma20 := genMA(dqs, 20)
-
-	code, _ := strings.CutPrefix(tblName, "stk")
-	dataset := []Dataset{GenCandleDataset(code, candles)}
-	dataset = append(dataset, GenVolumeDataset(volumes))
-	dataset = append(dataset, GenLineDataset("ma5", ma5, MA5_SKYBLUE))
-	dataset = append(dataset, GenLineDataset("ma10", ma10, MA10_YELLOW))
-	dataset = append(dataset, GenLineDataset("ma20", ma20, MA20_PURPLE))
-
-	config := GenCandleStickChartConfig(labels, dataset)
+	config := genTrendChart(tblName, dqs)
 	info := fmt.Sprintf("avg=%s(x%.2f)", toHumanized(avg/1000), float64(dqs[totalLen-1].Volume)/float64(avg))
 	return Result{Config: config, Info: info}, nil
 }
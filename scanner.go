@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
 	mydb "myDatabase"
 )
@@ -13,12 +13,6 @@ import (
 var ErrTooFewDays error = errors.New("too few days")
 var ErrNotInsterested error = errors.New("not interested")
 
-const SHOWING_QDS int = 60
-const BASE_QDS_NR int = 20
-const MIN_INTRESTED_VOL int64 = 800000 // qty 800,000
-
-const MAX_TRANSMIT_SIZE int = 32
-
 const TYPE_GAP_CALL int = 1
 const TYPE_GAP_PUT int = 2
 const TYPE_GAP_CALL_CLOSED int = 3
@@ -44,10 +38,15 @@ type Result struct {
 }
 
 type ScanRequest struct {
-	Op       string `json:"op"`
-	Option   string `json:"option,omitempty"`
-	Interval int    `json:"interval"`
-	Next     int    `json:"next"`
+	Op         string  `json:"op"`
+	Option     string  `json:"option,omitempty"`
+	Interval   int     `json:"interval"`
+	Next       int     `json:"next"`
+	Raw        bool    `json:"raw,omitempty"`        // prices as traded, not adjusted for ex-rights
+	Days       int     `json:"days,omitempty"`       // least streak of inst-streak
+	Margin     bool    `json:"margin,omitempty"`     // add the margin panel to charts
+	Percentile float64 `json:"percentile,omitempty"` // most percentile of valuation
+	Months     int     `json:"months,omitempty"`     // least growth months of revenue-growth
 }
 
 type Reply struct {
@@ -55,17 +54,6 @@ type Reply struct {
 	NextTblIdx int      `json:"next"`
 }
 
-func isWeekend(t time.Time) bool {
-	switch t.Weekday() {
-	case time.Saturday:
-		return true
-	case time.Sunday:
-		return true
-	default:
-		return false
-	}
-}
-
 func doScan(w http.ResponseWriter, r *http.Request) {
 	var req ScanRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -79,17 +67,22 @@ func doScan(w http.ResponseWriter, r *http.Request) {
 	option := req.Option
 	fmt.Printf("Start scan.. op=%s opt=%s interval=%d next=%d\n", op, option, interval, next)
 
-	err := updateFetch()
-	if err != nil {
-		writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
-		fmt.Println(err.Error())
+	if op == "refresh" {
+		err := scheduler.Refresh(r.Context())
+		if err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONOKResonse(w, scheduler.Status())
 		return
 	}
 
-	continueScan(w, next, op, option, interval)
+	// Quotes are kept fresh by the scheduler; scanning only reads the DB.
+	continueScan(w, req)
 }
 
-func continueScan(w http.ResponseWriter, tblIdx int, op string, option string, interval int) {
+func continueScan(w http.ResponseWriter, req ScanRequest) {
+	tblIdx, op, option, interval := req.Next, req.Op, req.Option, req.Interval
 	reply := Reply{}
 
 	if tblIdx < 0 {
@@ -105,28 +98,81 @@ func continueScan(w http.ResponseWriter, tblIdx int, op string, option string, i
 		return
 	}
 
+	// Ex-dividend days would look like gaps in raw prices.
+	getDailyQuote := mydb.GetAdjustedDailyQuote
+	if req.Raw {
+		getDailyQuote = mydb.GetDailyQuote
+	}
+
 	var result Result
 	var foundNr int = 0
 	for i := tblIdx; i < len(tables); i = i + 1 {
 		tblName := tables[i]
 		fmt.Printf("Getting DQ for %s...\r", tblName)
 
-		dayNr := interval + BASE_QDS_NR
-		if dayNr < SHOWING_QDS {
-			dayNr = SHOWING_QDS
+		dayNr := interval + conf.BaseQDsNr
+		if dayNr < conf.ShowingQDs {
+			dayNr = conf.ShowingQDs
 		}
-		dqs, err := mydb.GetDailyQuote(tblName, dayNr)
+		dqs, err := getDailyQuote(tblName, dayNr)
 		if err != nil {
 			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
 			fmt.Println("Failed to get DQ:", err.Error())
 			return
 		}
 
+		// Other ops only chart the flows, so they load them for matches only.
+		var flows []mydb.Institutional
+		if op == "inst-streak" {
+			flows, err = getFlows(tblName, dqs)
+			if err != nil {
+				writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+				fmt.Println("Failed to get institutional trades:", err.Error())
+				return
+			}
+		}
+
+		var margins []mydb.Margin
+		if len(dqs) > 0 && (req.Margin || op == "short-ratio") {
+			code, _ := strings.CutPrefix(tblName, mydb.STKPREFIX)
+			margins, err = mydb.GetMarginRange(code, mydb.DateRange{From: dqs[0].Date})
+			if err != nil {
+				writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+				fmt.Println("Failed to get margin balances:", err.Error())
+				return
+			}
+		}
+
 		switch op {
 		case "gap":
 			result, err = findGap(option, tblName, interval, dqs)
 		case "vol-burst":
 			result, err = findVolBurst(tblName, interval, dqs)
+		case "inst-streak":
+			result, err = findInstStreak(option, req.Days, tblName, interval, dqs, flows)
+		case "short-ratio":
+			result, err = findShortRatio(option, tblName, interval, dqs, margins)
+		case "revenue-growth":
+			code, _ := strings.CutPrefix(tblName, mydb.STKPREFIX)
+			var revenues []mydb.Revenue
+			months := max(req.Months, DEFAULT_GROWTH_MONTHS)
+			revenues, err = mydb.GetRevenue(code, months)
+			if err == nil {
+				result, err = findRevenueGrowth(option, req.Months, tblName, interval, dqs, revenues)
+			}
+		case "valuation":
+			metric := option
+			if metric == "" {
+				metric = mydb.METRIC_PE
+			}
+			code, _ := strings.CutPrefix(tblName, mydb.STKPREFIX)
+			var rank mydb.ValuationRank
+			rank, err = mydb.RankValuation(code, metric, valuationSince(conf.ValuationYears))
+			if err == mydb.ErrNoValuation {
+				continue
+			} else if err == nil {
+				result, err = findValuation(metric, req.Percentile, tblName, interval, dqs, rank)
+			}
 		default:
 			writeJSONErrResonse(w, "No such op code", http.StatusBadRequest)
 			fmt.Println("No such op code", op)
@@ -143,6 +189,18 @@ func continueScan(w http.ResponseWriter, tblIdx int, op string, option string, i
 		}
 
 		// fmt.Printf("Found candidate %s+\n", tblName)
+		if op != "inst-streak" {
+			flows, err = getFlows(tblName, dqs)
+			if err != nil {
+				writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+				fmt.Println("Failed to get institutional trades:", err.Error())
+				return
+			}
+		}
+		addFlowDatasets(&result, flows)
+		if req.Margin {
+			addMarginDatasets(&result, margins)
+		}
 		reply.Result = append(reply.Result, result)
 		foundNr += 1
 
@@ -153,7 +211,7 @@ func continueScan(w http.ResponseWriter, tblIdx int, op string, option string, i
 		// 	return
 		// }
 
-		if foundNr > MAX_TRANSMIT_SIZE {
+		if foundNr > conf.MaxTransmitSize {
 			reply.NextTblIdx = i + 1
 			fmt.Printf("Finished at i=%d\n", reply.NextTblIdx)
 			writeJSONOKResonse(w, reply)
@@ -168,12 +226,12 @@ func continueScan(w http.ResponseWriter, tblIdx int, op string, option string, i
 // Date in ascendent
 func genMA(dqs []mydb.DaliyQuote, maNr int) []DataPoint {
 	ma := []DataPoint{}
-	sum := 0.0
-	i := BASE_QDS_NR - maNr + 1
+	var sum mydb.Price = 0
+	i := conf.BaseQDsNr - maNr + 1
 
 	// fmt.Println("CheckingMA", maNr)
 
-	for i < BASE_QDS_NR {
+	for i < conf.BaseQDsNr {
 		sum += dqs[i].Close
 		// fmt.Printf("[%d] sum=%f\n", i, sum)
 		i += 1
@@ -181,8 +239,8 @@ func genMA(dqs []mydb.DaliyQuote, maNr int) []DataPoint {
 
 	for i < len(dqs) {
 		sum += dqs[i].Close
-		val := sum / float64(maNr)
-		mmdd := fmt.Sprintf("%02d%02d", dqs[i].Month, dqs[i].Day)
+		val := sum.Float64() / float64(maNr)
+		mmdd := toMMDD(&dqs[i])
 		ma = append(ma, GenXYDataPoint(mmdd, val))
 		// fmt.Printf("[%d] sum=%f(%f) ma=%f\n", i, sum, dqs[i].Close, ma)
 		sum -= dqs[i-maNr+1].Close
@@ -192,11 +250,32 @@ func genMA(dqs []mydb.DaliyQuote, maNr int) []DataPoint {
 	return ma
 }
 
+// Candles, volume and moving averages of the days after the MA base.
+func genTrendChart(tblName string, dqs []mydb.DaliyQuote) ChartConfig {
+	candles := []DataPoint{}
+	volumes := []DataPoint{}
+	labels := []string{}
+	for i := conf.BaseQDsNr; i < len(dqs); i += 1 {
+		mmdd := toMMDD(&dqs[i])
+		candles = append(candles, toCandleDataPoint(mmdd, &dqs[i]))
+		volumes = append(volumes, toVolumeDataPoint(mmdd, &dqs[i]))
+		labels = append(labels, mmdd)
+	}
+
+	code, _ := strings.CutPrefix(tblName, "stk")
+	dataset := []Dataset{GenCandleDataset(code, candles)}
+	dataset = append(dataset, GenVolumeDataset(volumes))
+	dataset = append(dataset, GenLineDataset("ma5", genMA(dqs, 5), MA5_SKYBLUE))
+	dataset = append(dataset, GenLineDataset("ma10", genMA(dqs, 10), MA10_YELLOW))
+	dataset = append(dataset, GenLineDataset("ma20", genMA(dqs, 20), MA20_PURPLE))
+	return GenCandleStickChartConfig(labels, dataset)
+}
+
 func toMMDD(dq *mydb.DaliyQuote) string {
-	return fmt.Sprintf("%02d%02d", dq.Month, dq.Day)
+	return dq.Date.Format("0102")
 }
 func toCandleDataPoint(mmdd string, dq *mydb.DaliyQuote) DataPoint {
-	return GenCandleDataPoint(mmdd, dq.Open, dq.High, dq.Low, dq.Close)
+	return GenCandleDataPoint(mmdd, dq.Open.Float64(), dq.High.Float64(), dq.Low.Float64(), dq.Close.Float64())
 }
 func toVolumeDataPoint(mmdd string, dq *mydb.DaliyQuote) DataPoint {
 	return GenXYDataPoint(mmdd, float64(dq.Volume/1000))
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	mydb "myDatabase"
+)
+
+// Exchange data is published in Taipei time. Taiwan has no DST, so a fixed
+// zone is a safe fallback on hosts without tzdata.
+var taipei *time.Location = loadTaipei()
+
+func loadTaipei() *time.Location {
+	loc, err := time.LoadLocation("Asia/Taipei")
+	if err != nil {
+		return time.FixedZone("CST", 8*60*60)
+	}
+	return loc
+}
+
+type FetchStatus struct {
+	Running   bool      `json:"running"`
+	LastStart time.Time `json:"lastStart"`
+	LastEnd   time.Time `json:"lastEnd"`
+	LastError string    `json:"lastError,omitempty"`
+	NextRun   time.Time `json:"nextRun"`
+	// What the last fetch did with the rows of the daily reports.
+	LastSummary FetchSummary `json:"lastSummary"`
+}
+
+// One fetch in flight. Callers joining it wait on done.
+type fetchRun struct {
+	done chan struct{}
+	err  error
+}
+
+// fetchScheduler runs updateFetch at most once at a time. Refreshes asked for
+// while a fetch is running join that fetch instead of starting another.
+type fetchScheduler struct {
+	mu      sync.Mutex
+	ctx     context.Context // cancels fetches at shutdown
+	running *fetchRun
+	status  FetchStatus
+}
+
+var scheduler fetchScheduler
+
+// Background jobs run under appCtx, which is cancelled at shutdown.
+var appCtx context.Context = context.Background()
+
+// Start a fetch unless one is running, and return the run to wait on.
+func (s *fetchScheduler) trigger() *fetchRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != nil {
+		return s.running
+	}
+
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	run := &fetchRun{done: make(chan struct{})}
+	s.running = run
+	s.status.Running = true
+	s.status.LastStart = time.Now()
+	go func() {
+		sum, err := updateFetch(ctx)
+		run.err = err
+
+		s.mu.Lock()
+		s.running = nil
+		s.status.Running = false
+		s.status.LastEnd = time.Now()
+		s.status.LastError = ""
+		s.status.LastSummary = sum
+		if run.err != nil {
+			s.status.LastError = run.err.Error()
+			fmt.Println("Fetch failed:", run.err.Error())
+		}
+		s.mu.Unlock()
+		close(run.done)
+	}()
+	return run
+}
+
+// Refresh fetches missing days, joining a running fetch if there is one.
+func (s *fetchScheduler) Refresh(ctx context.Context) error {
+	run := s.trigger()
+	select {
+	case <-run.done:
+		return run.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait for the running fetch, if any, to end.
+func (s *fetchScheduler) Wait() {
+	s.mu.Lock()
+	run := s.running
+	s.mu.Unlock()
+	if run != nil {
+		<-run.done
+	}
+}
+
+func (s *fetchScheduler) Status() FetchStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// First fetch time after now, on a trading day.
+func nextFetchTime(now time.Time) time.Time {
+	now = now.In(taipei)
+	y, m, d := now.Date()
+	hour, min := conf.fetchClock()
+	next := time.Date(y, m, d, hour, min, 0, 0, taipei)
+	for !next.After(now) || !mydb.IsTradingDay(mydb.DateOf(next)) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Run catches up once at start, then fetches every trading day after market
+// close until ctx is done. Cancelling ctx also aborts a running fetch.
+func (s *fetchScheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	s.trigger()
+	for {
+		next := nextFetchTime(time.Now())
+		s.mu.Lock()
+		s.status.NextRun = next
+		s.mu.Unlock()
+		fmt.Printf("Next fetch at %s\n", next.Format(time.DateTime))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.trigger()
+		}
+	}
+}
+
+type FetchStatusReply struct {
+	Scheduler FetchStatus `json:"scheduler"`
+	Gaps      []FetchGap  `json:"gaps"`
+}
+
+func fetchStatusHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		gaps, err := fetchGaps()
+		if err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONOKResonse(w, FetchStatusReply{Scheduler: scheduler.Status(), Gaps: gaps})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mydb "myDatabase"
+)
+
+// sideReport is a daily report besides the quotes, such as ex-rights or
+// institutional trades. Each is fetched and tracked in the fetch log under
+// its own market name, and archived like the quote reports.
+type sideReport struct {
+	market     string
+	urlFmt     string // formatted with year, month, day; of start and end too if ranged
+	ranged     bool
+	latest     bool // urlFmt is the URL of the latest report, used as is
+	client     *exchangeClient
+	archiveDir string
+	load       func(body []byte, d mydb.Date) (rowNr int, rejects []mydb.Reject, err error) // parse and store
+}
+
+// Created at startup from the config.
+var sideReports []*sideReport
+
+func newSideReports(client *exchangeClient) []*sideReport {
+	return []*sideReport{
+		{MARKET_TWSE_EXRIGHT, conf.TWSEExRightURL, true, false, client, conf.ArchiveDir, loadWith(parseExRights, mydb.PutExRights)},
+		{MARKET_TPEX_EXRIGHT, conf.TPExExRightURL, true, false, client, conf.ArchiveDir, loadWith(parseExRights, mydb.PutExRights)},
+		{MARKET_TWSE_INST, conf.TWSEInstURL, false, false, client, conf.ArchiveDir, loadWith(parseTWSEInst, mydb.PutInstitutional)},
+		{MARKET_TPEX_INST, conf.TPExInstURL, false, false, client, conf.ArchiveDir, loadWith(parseTPExInst, mydb.PutInstitutional)},
+		{MARKET_TWSE_MARGIN, conf.TWSEMarginURL, false, false, client, conf.ArchiveDir, loadWith(parseTWSEMargin, mydb.PutMargins)},
+		{MARKET_TPEX_MARGIN, conf.TPExMarginURL, false, false, client, conf.ArchiveDir, loadWith(parseTPExMargin, mydb.PutMargins)},
+		{MARKET_TWSE_VALUE, conf.TWSEValueURL, false, false, client, conf.ArchiveDir, loadWith(parseValuation, mydb.PutValuations)},
+		{MARKET_TPEX_VALUE, conf.TPExValueURL, false, false, client, conf.ArchiveDir, loadWith(parseValuation, mydb.PutValuations)},
+	}
+}
+
+// Parser of a side report. A row that cannot be read is rejected rather than
+// failing the report.
+type sideParser[T any] func(body []byte, d mydb.Date) (list []T, rejects []mydb.Reject, err error)
+
+// Load function of a report that parses it and stores the rows with put.
+func loadWith[T any](parse sideParser[T], put func([]T) error) func(body []byte, d mydb.Date) (int, []mydb.Reject, error) {
+	return func(body []byte, d mydb.Date) (int, []mydb.Reject, error) {
+		list, rejects, err := parse(body, d)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err = put(list); err != nil {
+			return 0, nil, err
+		}
+		return len(list), rejects, nil
+	}
+}
+
+// Fetch and store the report of d, or of from to to if ranged. Rows without
+// a date of their own are dated from. An undated URL is fetched as is, and
+// its report archived under from. A daily report without rows is not out yet
+// and gives ErrNoEntry; only a ranged one, like ex-rights, may be empty.
+func (s *sideReport) fetch(ctx context.Context, from mydb.Date, to mydb.Date) (rowNr int, err error) {
+	y, m, d := from.Date()
+	args := []any{y, int(m), d}
+	if s.ranged {
+		y, m, d = to.Date()
+		args = append(args, y, int(m), d)
+	} else if !from.Equal(to) {
+		return 0, fmt.Errorf("%s takes one day at a time", s.market)
+	}
+	url := s.urlFmt
+	if !s.latest {
+		url = fmt.Sprintf(s.urlFmt, args...)
+	}
+	fmt.Printf("Fetching %s...\n", url)
+
+	body, err := s.client.GetJSON(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	if from.Equal(to) {
+		if err = archiveReport(s.archiveDir, s.market, from, body); err != nil {
+			fmt.Printf("Failed to archive %s %s: %s\n", s.market, from, err.Error())
+		}
+	}
+	rowNr, rejects, err := s.load(body, from)
+	if err != nil {
+		return 0, err
+	}
+	for i := range rejects {
+		rejects[i].Market = s.market
+		fmt.Printf("Reject %s row %s: %s\n", s.market, rejects[i].Code, rejects[i].Reason)
+	}
+	if len(rejects) > 0 {
+		if err = mydb.PutRejects(rejects); err != nil {
+			return rowNr, err
+		}
+	}
+	if rowNr == 0 && !s.ranged {
+		return 0, ErrNoEntry
+	}
+	return rowNr, nil
+}
+
+// A report table with named columns. TWSE puts it at the top level, TPEx in
+// tables.
+type SideReport struct {
+	Stat   string      `json:"stat"`
+	Fields []string    `json:"fields"`
+	Data   [][]string  `json:"data"`
+	Tables []SideTable `json:"tables"`
+}
+type SideTable struct {
+	Title  string     `json:"title"`
+	Fields []string   `json:"fields"`
+	Data   [][]string `json:"data"`
+}
+
+// The non-empty tables of body.
+func sideTables(body []byte) ([]SideTable, error) {
+	var report SideReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, err
+	}
+	tables := []SideTable{}
+	for _, tbl := range append([]SideTable{{Fields: report.Fields, Data: report.Data}}, report.Tables...) {
+		if len(tbl.Data) > 0 {
+			tables = append(tables, tbl)
+		}
+	}
+	return tables, nil
+}
+
+// Index of the first field containing name, or -1.
+func fieldIndex(fields []string, name string) int {
+	for i, f := range fields {
+		if strings.Contains(f, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Index of the field named exactly name, spaces aside, or -1.
+func fieldExact(fields []string, name string) int {
+	return fieldNth(fields, name, 0)
+}
+
+// Index of the n-th field, from 0, named exactly name, or -1. Reports with
+// grouped headers repeat names.
+func fieldNth(fields []string, name string, n int) int {
+	for i, f := range fields {
+		if strings.ReplaceAll(f, " ", "") != name {
+			continue
+		}
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return -1
+}
@@ -2,12 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math"
 	"net/http"
+	"slices"
 	"sort"
-	"time"
 
 	mydb "myDatabase"
 )
@@ -15,141 +14,196 @@ import (
 type StatisRequest struct {
 	Op       string `json:"op"`
 	Interval int    `json:"interval"`
+	From     string `json:"from,omitempty"`  // YYYYMMDD or YYYY-MM-DD
+	To       string `json:"to,omitempty"`    // YYYYMMDD or YYYY-MM-DD
+	Group    string `json:"group,omitempty"` // day, month, quarter, year
+	Date     string `json:"date,omitempty"`  // YYYYMMDD or YYYY-MM-DD
 }
 type StatisReply struct {
-	Result       []Result `json:"result"`
-	NextTblIdx   int      `json:"next"`
-	MarketNets   []int64  `json:"marketnets,omitempty"`
-	Values       int64    `json:"values,omitempty"`
-	MarketValues int64    `json:"marketvals,omitempty"`
+	Result       []Result     `json:"result"`
+	NextTblIdx   int          `json:"next"`
+	MarketNets   []mydb.Money `json:"marketnets,omitempty"`
+	Values       mydb.Money   `json:"values,omitempty"`
+	MarketValues mydb.Money   `json:"marketvals,omitempty"`
 }
+
+var ErrBadGroup error = errors.New("invalid group")
+var ErrBadRange error = errors.New("invalid date range")
+
 type OldReply struct {
-	Labels []string `json:"labels"`
-	Data   []int    `json:"data"`
+	Labels []string     `json:"labels"`
+	Data   []mydb.Money `json:"data"`
 }
 
 func doStatistic(w http.ResponseWriter, r *http.Request) {
 	var req StatisRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSONErrResonse(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	switch req.Op {
 	case "init":
-		err := genAllTimeGain()
+		err := rebuildLedger()
 		if err != nil {
-			writeJSONErrResonse(w, err.Error(), http.StatusBadRequest)
+			writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		writeJSONOKResonse(w, map[string]string{})
 	case "gain":
 		reply, err := calGain(req.Interval)
 		if err != nil {
 			writeJSONErrResonse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSONOKResonse(w, reply)
+	case "gainrange":
+		reply, err := calGainRange(req.From, req.To, req.Group)
+		if err != nil {
+			writeJSONErrResonse(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 		writeJSONOKResonse(w, reply)
 	case "holding":
 		getHolding(w)
+	case "holdingat":
+		getHoldingAt(w, req.Date)
 	}
 }
 
-func genAllTimeGain() error {
-	err := mydb.ResetTbl(mydb.HOLDING_TABLENAME)
+// Rebuild holdings and realized tables from the whole transaction table.
+// The watermark only notices new ids, so this is what picks up trades edited
+// or deleted in the table directly.
+func rebuildLedger() error {
+	return mydb.RunLedger(func(l *mydb.LedgerTx) error {
+		if err := l.SetLedgerMark(mydb.LedgerMark{}); err != nil {
+			return err
+		}
+		return syncLedger(l)
+	})
+}
+
+// Record t together with its effect on holdings and realized gains. Either
+// all of it lands or none of it.
+func recordTrade(t mydb.Transaction) error {
+	return mydb.RunLedger(func(l *mydb.LedgerTx) error {
+		if _, err := l.AddTransaction(t); err != nil {
+			return err
+		}
+		return syncLedger(l)
+	})
+}
+
+// New trades dated on or after the watermark are simply appended. A back-dated
+// trade rewinds the ledger to its date and replays everything after it.
+func syncLedger(l *mydb.LedgerTx) (err error) {
+	mark, err := l.GetLedgerMark()
 	if err != nil {
-		log.Fatalln("errResetHolding", err.Error())
+		return err
 	}
-	err = mydb.ResetTbl(mydb.REALIZED_TABLENAME)
+	newTrans, err := l.GetTransactionsAfterId(mark.LastId)
 	if err != nil {
-		log.Fatalln("errResetRealized", err.Error())
+		return err
 	}
-	err = mydb.VacuumDB()
-	if err != nil {
-		log.Fatalln("errVacuum", err.Error())
+	if len(newTrans) == 0 && mark.LastId != 0 {
+		return nil
 	}
 
-	trans, err := mydb.ScanTransaction()
-	if err != nil {
-		fmt.Println("Some error ", err.Error())
-		return err
+	trans := newTrans
+	if mark.LastId == 0 || newTrans[0].Date.Before(mark.LastDate) {
+		from := mydb.Date{}
+		if mark.LastId != 0 {
+			from = newTrans[0].Date
+		}
+		fmt.Printf("Replaying ledger from %s\n", from)
+
+		prior := []mydb.Transaction{}
+		if !from.IsZero() {
+			prior, err = l.GetTransactionRange(mydb.DateRange{To: from.AddDays(-1)})
+		}
+		if err != nil {
+			return err
+		}
+		err = l.ResetHoldings(replayHoldings(prior))
+		if err != nil {
+			return err
+		}
+		err = l.DeleteRealizedSince(from)
+		if err != nil {
+			return err
+		}
+		trans, err = l.GetTransactionRange(mydb.DateRange{From: from})
+		if err != nil {
+			return err
+		}
 	}
 
 	totalNr := len(trans)
 	for i, v := range trans {
-		err = procTrans(v)
+		err = procTrans(l, v)
 		if err != nil {
 			fmt.Println("Failed")
 			return err
 		}
-		fmt.Printf("\rProgress:%d/%d", i, totalNr)
+		fmt.Printf("\rProgress:%d/%d", i+1, totalNr)
 	}
-	fmt.Println("Complete")
-	return nil
+	fmt.Println(" Complete")
+
+	for _, v := range newTrans {
+		mark.LastId = max(mark.LastId, v.Id)
+		if v.Date.After(mark.LastDate) {
+			mark.LastDate = v.Date
+		}
+	}
+	return l.SetLedgerMark(mark)
 }
 
-func procTrans(v mydb.Transaction) error {
+func procTrans(l *mydb.LedgerTx, v mydb.Transaction) error {
 	if v.Direction {
 		// Buy
-		h := mydb.Holding{Code: v.Code, Year: v.Year, Month: v.Month, Day: v.Day, Quantity: v.Quantity, Net: v.Net}
-		mydb.AddHolding(h)
-		return nil
+		h := mydb.Holding{Code: v.Code, Date: v.Date, Quantity: v.Quantity, Net: v.Net}
+		return l.AddHolding(h)
 	}
 
 	// Sell
-	holdings, err := mydb.GetHolding(v.Code)
+	holdings, err := l.GetHolding(v.Code)
 	if err != nil {
 		fmt.Println("Error for scan", v.Code, err.Error())
 		return err
 	}
 
-	remain := v.Quantity
-	remainNet := v.Net
-	gain := 0
-	for _, h := range holdings {
-		nr, err := mydb.DecHolding(h, remain)
-		if err != nil {
-			fmt.Println("Some error for dec", v.Code, v.Year, v.Month, v.Day, err.Error())
-			return err
-		}
-
-		// v should be selling. h should be bought holdings
-		hRatio := float64(nr) / float64(h.Quantity)
-		// fmt.Printf("hRatio %f=%d/%d\n", hRatio, nr, h.Quantity)
-		vRatio := float64(nr) / float64(remain)
-		// fmt.Printf("vRatio %f=%d/%d\n", vRatio, nr, remain)
-		vUsed := int(math.Round(float64(remainNet) * vRatio))
-		// fmt.Printf("vUsed %d=%d*%f\n", vUsed, remainNet, vRatio)
-		gain += vUsed - int(math.Round(float64(h.Net)*hRatio))
-		// fmt.Printf("gan=%d=%d-(%d*%f)\n", gain, vUsed, h.Net, hRatio)
-		remain -= nr
-		remainNet -= vUsed
-		if remain == 0 {
-			break
-		}
-	}
-	if remain != 0 {
-		fmt.Printf("Remaining for code=%s at %d/%d/%d... May be missing buy info.\n", v.Code, v.Year, v.Month, v.Day)
-		if remain == v.Quantity {
+	rest, sold, gain := sellLots(holdings, v)
+	if sold != v.Quantity {
+		fmt.Printf("Remaining for code=%s at %s... May be missing buy info.\n", v.Code, v.Date)
+		if sold == 0 {
 			// No need to add realized
-			return err
+			return nil
 		}
 	}
+	err = l.ReplaceHoldings(v.Code, rest)
+	if err != nil {
+		fmt.Println("Error for update holdings", v.Code, v.Date, err.Error())
+		return err
+	}
 
-	realized := mydb.Holding{Code: v.Code, Year: v.Year, Month: v.Month, Day: v.Day, Quantity: (v.Quantity - remain), Net: gain}
-	err = mydb.AddRealized(realized)
+	realized := mydb.Holding{Code: v.Code, Date: v.Date, Quantity: sold, Net: gain}
+	err = l.AddRealized(realized)
 	if err != nil {
-		fmt.Println("Error for add realized", v.Code, v.Year, v.Month, v.Day, err.Error())
+		fmt.Println("Error for add realized", v.Code, v.Date, err.Error())
 		return err
 	}
 	return nil
 }
 
 func calGain(interval int) (reply OldReply, err error) {
-	y, d, m := time.Now().AddDate(0, -interval, 0).Date()
-	realizeds, err := mydb.GetRelized(y, int(d), m)
+	to := mydb.Today()
+	from := mydb.Date{Time: to.AddDate(0, -interval, 0)}
+	realizeds, err := mydb.GetRealizedRange(mydb.DateRange{From: from, To: to})
 	if err != nil {
 		return reply, err
 	}
 
-	rmap := make(map[string]int)
+	rmap := make(map[string]mydb.Money)
 	for _, ent := range realizeds {
 		if val, exist := rmap[ent.Code]; exist {
 			rmap[ent.Code] = val + ent.Net
@@ -176,18 +230,186 @@ func calGain(interval int) (reply OldReply, err error) {
 	return reply, nil
 }
 
-func appendToReplyList(prev mydb.Holding, labels *[]string, nets *[]float64, marketNets *[]int64,
-	bgColor *[]string, holdingValues *int, marketValues *float64) string {
+func parseDateArg(str string, def mydb.Date) (mydb.Date, error) {
+	if str == "" {
+		return def, nil
+	}
+	return mydb.ParseDate(str)
+}
+
+func groupKey(group string, date mydb.Date) (string, error) {
+	y, m, d := date.Year(), int(date.Month()), date.Day()
+	switch group {
+	case "day":
+		return fmt.Sprintf("%04d%02d%02d", y, m, d), nil
+	case "", "month":
+		return fmt.Sprintf("%04d%02d", y, m), nil
+	case "quarter":
+		return fmt.Sprintf("%04dQ%d", y, (m-1)/3+1), nil
+	case "year":
+		return fmt.Sprintf("%04d", y), nil
+	}
+	return "", ErrBadGroup
+}
+
+// Realized gain within [from, to], grouped by period and stacked by stock.
+func calGainRange(fromStr string, toStr string, group string) (reply StatisReply, err error) {
+	to, err := parseDateArg(toStr, mydb.Today())
+	if err != nil {
+		return reply, err
+	}
+	from, err := parseDateArg(fromStr, mydb.Date{Time: to.AddDate(-1, 0, 0)})
+	if err != nil {
+		return reply, err
+	}
+	if to.Before(from) {
+		return reply, ErrBadRange
+	}
+	if _, err = groupKey(group, from); err != nil {
+		return reply, err
+	}
+
+	realizeds, err := mydb.GetRealizedRange(mydb.DateRange{From: from, To: to})
+	if err != nil {
+		return reply, err
+	}
+
+	periods := []string{}
+	periodIdx := make(map[string]int)
+	codes := []string{}
+	gains := make(map[string]map[int]mydb.Money)
+	for _, ent := range realizeds {
+		key, _ := groupKey(group, ent.Date)
+		// Realized entries are ordered by date, so periods come out sorted.
+		idx, exist := periodIdx[key]
+		if !exist {
+			idx = len(periods)
+			periodIdx[key] = idx
+			periods = append(periods, key)
+		}
+		if _, exist := gains[ent.Code]; !exist {
+			gains[ent.Code] = make(map[int]mydb.Money)
+			codes = append(codes, ent.Code)
+		}
+		gains[ent.Code][idx] += ent.Net
+	}
+	sort.Strings(codes)
+
+	datasets := []GenericDataset{}
+	for _, code := range codes {
+		name, err := mydb.RefLookupNameByCode(code)
+		if err != nil {
+			return reply, err
+		}
+		data := make([]float64, len(periods))
+		for idx, val := range gains[code] {
+			data[idx] = float64(val)
+		}
+		datasets = append(datasets, GenStackedBarDataset(code+name, data, GenBGColor()))
+	}
+
+	config := GenGenericChartConfig("bar", periods, datasets)
+	SetStackedScales(&config)
+	reply.Result = []Result{{Config: config}}
+	return reply, nil
+}
+
+// Sell v out of lots, oldest first. Returns the lots left, the quantity
+// they covered and its realized gain. Both procTrans and replayHoldings match
+// lots here, so the stored and replayed ledgers can't drift apart.
+func sellLots(lots []mydb.Holding, v mydb.Transaction) (rest []mydb.Holding, sold int, gain mydb.Money) {
+	rest = slices.Clone(lots)
+	remain := v.Quantity
+	remainNet := v.Net
+	for len(rest) > 0 && remain > 0 {
+		h := &rest[0]
+		nr := min(remain, h.Quantity)
+		hUsed, hRest := mydb.Prorate(h.Net, nr, h.Quantity)
+		vUsed, _ := mydb.Prorate(remainNet, nr, remain)
+		gain += vUsed - hUsed
+		remain -= nr
+		remainNet -= vUsed
+		if nr == h.Quantity {
+			rest = rest[1:]
+			continue
+		}
+		h.Quantity -= nr
+		h.Net = hRest
+	}
+	return rest, v.Quantity - remain, gain
+}
+
+// Replay transactions in memory, matching lots like procTrans.
+// Result is ordered by code and buy date, like GetHoldingAll.
+func replayHoldings(trans []mydb.Transaction) []mydb.Holding {
+	lots := make(map[string][]mydb.Holding)
+	for _, v := range trans {
+		if v.Direction {
+			h := mydb.Holding{Code: v.Code, Date: v.Date, Quantity: v.Quantity, Net: v.Net}
+			lots[v.Code] = append(lots[v.Code], h)
+			continue
+		}
+		lots[v.Code], _, _ = sellLots(lots[v.Code], v)
+	}
+
+	codes := make([]string, 0, len(lots))
+	for k := range lots {
+		codes = append(codes, k)
+	}
+	sort.Strings(codes)
+
+	holdings := []mydb.Holding{}
+	for _, k := range codes {
+		holdings = append(holdings, lots[k]...)
+	}
+	return holdings
+}
+
+func getHoldingAt(w http.ResponseWriter, dateStr string) {
+	at, err := mydb.ParseDate(dateStr)
+	if err != nil {
+		writeJSONErrResonse(w, "Invalid date format", http.StatusBadRequest)
+		return
+	}
+
+	trans, err := mydb.GetTransactionRange(mydb.DateRange{To: at})
+	if err != nil {
+		writeJSONErrResonse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeHoldingReply(w, replayHoldings(trans), at)
+}
+
+// Close price of code at the given day, or the latest one if at is zero.
+func closeAt(code string, at mydb.Date) (mydb.Price, error) {
+	if at.IsZero() {
+		dq, err := mydb.GetDailyQuote(mydb.STKPREFIX+code, 1)
+		if err != nil || len(dq) == 0 {
+			return 0, mydb.ErrNoSuchTable
+		}
+		return dq[0].Close, nil
+	}
+
+	dq, err := mydb.FindPrevDailyQuote(code, at.AddDays(1))
+	if err != nil || dq.Date.IsZero() {
+		return 0, mydb.ErrNoSuchTable
+	}
+	return dq.Close, nil
+}
+
+func appendToReplyList(prev mydb.Holding, labels *[]string, nets *[]float64, marketNets *[]mydb.Money,
+	bgColor *[]string, holdingValues *mydb.Money, marketValues *mydb.Money, at mydb.Date) string {
 
 	name, err := mydb.RefLookupNameByCode(prev.Code)
 	if err != nil {
 		return "Code-Name pair not found"
 	}
 
-	var mknet float64 = 0.0
-	dq, err := mydb.GetDailyQuote(mydb.STKPREFIX+prev.Code, 1)
+	var mknet mydb.Money = 0
+	price, err := closeAt(prev.Code, at)
 	if err == nil {
-		mknet = dq[0].Close * float64(prev.Quantity)
+		mknet = price.Mul(prev.Quantity)
 		*marketValues += mknet
 	}
 
@@ -195,23 +417,32 @@ func appendToReplyList(prev mydb.Holding, labels *[]string, nets *[]float64, mar
 
 	*labels = append(*labels, prev.Code+name)
 	*nets = append(*nets, float64(prev.Net))
-	*marketNets = append(*marketNets, int64(mknet))
+	*marketNets = append(*marketNets, mknet)
 	*bgColor = append(*bgColor, GenBGColor())
 
 	return ""
 }
 
 func getHolding(w http.ResponseWriter) {
+	holdings, err := mydb.GetHoldingAll()
+	if err != nil {
+		return
+	}
+	writeHoldingReply(w, holdings, mydb.Date{})
+}
+
+// Holdings must be ordered by code. Market values are priced at the given day.
+func writeHoldingReply(w http.ResponseWriter, holdings []mydb.Holding, at mydb.Date) {
 	labels := []string{}
 	bgColor := []string{}
 	nets := []float64{}
-	marketNets := []int64{}
+	marketNets := []mydb.Money{}
 	prev := mydb.Holding{}
-	holdingValues := 0
-	marketValues := 0.0
+	var holdingValues mydb.Money = 0
+	var marketValues mydb.Money = 0
 
-	holdings, err := mydb.GetHoldingAll()
-	if err != nil {
+	if len(holdings) == 0 {
+		writeJSONOKResonse(w, StatisReply{NextTblIdx: 0})
 		return
 	}
 
@@ -226,7 +457,7 @@ func getHolding(w http.ResponseWriter) {
 			prev.Net += ent.Net
 		} else {
 			// fmt.Printf("== %v\n", prev)
-			errstr := appendToReplyList(prev, &labels, &nets, &marketNets, &bgColor, &holdingValues, &marketValues)
+			errstr := appendToReplyList(prev, &labels, &nets, &marketNets, &bgColor, &holdingValues, &marketValues, at)
 			if errstr != "" {
 				writeJSONErrResonse(w, "Code-Name pair not found", http.StatusInternalServerError)
 				return
@@ -235,7 +466,7 @@ func getHolding(w http.ResponseWriter) {
 		}
 	}
 	// fmt.Printf("== %v\n", prev)
-	errstr := appendToReplyList(prev, &labels, &nets, &marketNets, &bgColor, &holdingValues, &marketValues)
+	errstr := appendToReplyList(prev, &labels, &nets, &marketNets, &bgColor, &holdingValues, &marketValues, at)
 	if errstr != "" {
 		writeJSONErrResonse(w, "Code-Name pair not found", http.StatusInternalServerError)
 		return
@@ -245,7 +476,7 @@ func getHolding(w http.ResponseWriter) {
 	config := GenGenericChartConfig("doughnut", labels, []GenericDataset{ds})
 
 	res := Result{Config: config}
-	reply := StatisReply{Result: []Result{res}, NextTblIdx: 0, MarketNets: marketNets, Values: int64(holdingValues), MarketValues: int64(marketValues)}
+	reply := StatisReply{Result: []Result{res}, NextTblIdx: 0, MarketNets: marketNets, Values: holdingValues, MarketValues: marketValues}
 
 	writeJSONOKResonse(w, reply)
 }
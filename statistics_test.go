@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	mydb "myDatabase"
+)
+
+func day(d int) mydb.Date {
+	return mydb.NewDate(2024, 3, d)
+}
+
+func trade(code string, buy bool, d int, qty int, net mydb.Money) mydb.Transaction {
+	return mydb.Transaction{Code: code, Date: day(d), Direction: buy, Price: 100000, Quantity: qty, Net: net}
+}
+
+func lot(code string, d int, qty int, net mydb.Money) mydb.Holding {
+	return mydb.Holding{Code: code, Date: day(d), Quantity: qty, Net: net}
+}
+
+func sameLots(a []mydb.Holding, b []mydb.Holding) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Code != b[i].Code || !a[i].Date.Equal(b[i].Date) || a[i].Quantity != b[i].Quantity || a[i].Net != b[i].Net {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSellLots(t *testing.T) {
+	lots := []mydb.Holding{lot("2330", 1, 1000, 100000), lot("2330", 2, 2000, 220000)}
+	cases := []struct {
+		name string
+		lots []mydb.Holding
+		sell mydb.Transaction
+		rest []mydb.Holding
+		sold int
+		gain mydb.Money
+	}{
+		{"part of first lot", lots, trade("2330", false, 5, 500, 60000),
+			[]mydb.Holding{lot("2330", 1, 500, 50000), lot("2330", 2, 2000, 220000)}, 500, 10000},
+		{"whole first lot", lots, trade("2330", false, 5, 1000, 130000),
+			[]mydb.Holding{lot("2330", 2, 2000, 220000)}, 1000, 30000},
+		{"into second lot", lots, trade("2330", false, 5, 1500, 180000),
+			[]mydb.Holding{lot("2330", 2, 1500, 165000)}, 1500, 25000},
+		{"more than held", lots, trade("2330", false, 5, 4000, 400000),
+			[]mydb.Holding{}, 3000, -20000},
+		{"nothing held", nil, trade("2330", false, 5, 1000, 100000),
+			[]mydb.Holding{}, 0, 0},
+	}
+	for _, c := range cases {
+		rest, sold, gain := sellLots(c.lots, c.sell)
+		if !sameLots(rest, c.rest) || sold != c.sold || gain != c.gain {
+			t.Errorf("%s: sellLots = %v, %d, %d, want %v, %d, %d", c.name, rest, sold, gain, c.rest, c.sold, c.gain)
+		}
+	}
+	if !sameLots(lots, []mydb.Holding{lot("2330", 1, 1000, 100000), lot("2330", 2, 2000, 220000)}) {
+		t.Errorf("sellLots changed its lots: %v", lots)
+	}
+}
+
+func TestReplayHoldings(t *testing.T) {
+	cases := []struct {
+		name  string
+		trans []mydb.Transaction
+		want  []mydb.Holding
+	}{
+		{"none", nil, []mydb.Holding{}},
+		{"ordered by code", []mydb.Transaction{
+			trade("2330", true, 1, 1000, 100000),
+			trade("0050", true, 2, 2000, 300000),
+			trade("2330", true, 3, 1000, 120000),
+			trade("2330", false, 4, 1500, 180000),
+		}, []mydb.Holding{lot("0050", 2, 2000, 300000), lot("2330", 3, 500, 60000)}},
+		{"sold out", []mydb.Transaction{
+			trade("2330", true, 1, 1000, 100000),
+			trade("2330", false, 2, 1000, 110000),
+		}, []mydb.Holding{}},
+	}
+	for _, c := range cases {
+		if got := replayHoldings(c.trans); !sameLots(got, c.want) {
+			t.Errorf("%s: replayHoldings = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func openTestDB(t *testing.T) {
+	dir := t.TempDir()
+	mydb.InitMyDB(mydb.Config{
+		MainPath:   filepath.Join(dir, "main.sqlite"),
+		DailyPath:  filepath.Join(dir, "daily.sqlite"),
+		BackupDir:  filepath.Join(dir, "backup"),
+		BackupKeep: 1,
+	})
+	t.Cleanup(mydb.CloseMyDB)
+}
+
+// A trade dated before the ones already processed changes which lot the
+// later sell takes.
+func TestSyncLedgerBackdated(t *testing.T) {
+	openTestDB(t)
+	for _, v := range []mydb.Transaction{
+		trade("2330", true, 4, 1000, 100000),
+		trade("2330", true, 6, 1000, 120000),
+		trade("2330", false, 8, 1000, 150000),
+		trade("2330", true, 2, 1000, 80000),
+	} {
+		if err := recordTrade(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	holdings, err := mydb.GetHoldingAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mydb.Holding{lot("2330", 4, 1000, 100000), lot("2330", 6, 1000, 120000)}
+	if !sameLots(holdings, want) {
+		t.Errorf("holdings = %v, want %v", holdings, want)
+	}
+
+	realized, err := mydb.GetRealizedRange(mydb.DateRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []mydb.Holding{lot("2330", 8, 1000, 70000)}
+	if !sameLots(realized, want) {
+		t.Errorf("realized = %v, want %v", realized, want)
+	}
+}
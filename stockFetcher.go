@@ -1,12 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
@@ -15,21 +14,18 @@ import (
 	mydb "myDatabase"
 )
 
-const FETCH_DELAY_SEC float64 = 3
-
-const TWSE_API_URL string = "https://www.twse.com.tw/exchangeReport/MI_INDEX?response=json&date=%04d%02d%02d&type=ALLBUT0999"
-const TPEX_API_URL string = "https://www.tpex.org.tw/www/zh-tw/afterTrading/otc?date=%04d/%02d/%02d&type=EW&response=json"
-
-const DATA_TYPE_TWSE int = 1
-const DATA_TYPE_TPEX int = 2
+// Market names in the fetch log.
+const MARKET_TWSE string = "twse"
+const MARKET_TPEX string = "tpex"
 
 var ErrNoEntry error = errors.New("no data")
 var ErrFetchBad error = errors.New("fetch bad status code")
 var ErrNoVolume error = errors.New("no trade volume")
 var ErrNotStock error = errors.New("not ordinary stock")
 
-var lastTWSEFetchTime time.Time = time.Now()
-var lastTPExFetchTime time.Time = time.Now()
+// Created at startup from the config.
+var exClient *exchangeClient
+var quoteProviders []QuoteProvider
 
 type TWSEReport struct {
 	Tables []TWSETable `json:"tables"`
@@ -62,192 +58,315 @@ type TPExTables struct {
 	Notes              []json.RawMessage `json:"notes"`
 }
 
-func dayBeforeInclude(D1 time.Time, D2 time.Time) bool {
-	y1, m1, d1 := D1.Date()
-	y2, m2, d2 := D2.Date()
-
-	if y1 < y2 || y1 == y2 && m1 < m2 || y1 == y2 && m1 == m2 && d1 <= d2 {
-		return true
+// Last day whose reports should be out. Today's are out only after the fetch
+// time.
+func lastReportDay() mydb.Date {
+	now := time.Now().In(taipei)
+	end := mydb.DateOf(now)
+	hour, min := conf.fetchClock()
+	if now.Hour()*60+now.Minute() < hour*60+min {
+		end = end.AddDays(-1)
 	}
-	return false
+	return end
 }
 
-func updateFetch() error {
-	var i int = 0
-	end := time.Now()
-	if end.Hour() < 15 {
-		end = end.AddDate(0, 0, -1)
+// Trading days within the fetch window, and the fetch records of them.
+func fetchWindow() (days []mydb.Date, logs map[string]mydb.FetchLog, err error) {
+	end := lastReportDay()
+	r := mydb.DateRange{From: end.AddDays(-conf.FetchDays), To: end}
+	list, err := mydb.GetFetchLogRange(r)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	start := mydb.GetDQCheckedDate()
-	now := start.AddDate(0, 0, 1)
-	fmt.Printf("Check date is %s->%s (%s)\n", start.Format("20060102"), end.Format("20060102"), now.Format("20060102"))
-
-	for dayBeforeInclude(now, end) {
-		if isWeekend(now) {
-			now = now.AddDate(0, 0, 1)
-			continue
-		}
-		y, m, d := now.Date()
-		err := fetch(DATA_TYPE_TWSE, y, int(m), d)
-		if err != nil {
-			return err
-		}
-		err = fetch(DATA_TYPE_TPEX, y, int(m), d)
-		if err != nil {
-			return err
-		}
-		i = i + 1
-		now = now.AddDate(0, 0, 1)
+	logs = map[string]mydb.FetchLog{}
+	for _, l := range list {
+		logs[l.Date.String()+l.Market] = l
 	}
-
-	mydb.SetDQCheckedDate(end)
-	return nil
+	return mydb.TradingDaysBetween(r.From, r.To), logs, nil
 }
 
-func fetch(stkType int, y int, m int, d int) error {
-	var url string
-	var duration time.Duration
-	if stkType == DATA_TYPE_TWSE {
-		url = TWSE_API_URL
-		duration = time.Since(lastTWSEFetchTime)
-	} else if stkType == DATA_TYPE_TPEX {
-		duration = time.Since(lastTPExFetchTime)
-		url = TPEX_API_URL
-	} else {
-		return errors.New("invalid stock type")
-	}
-
-	if duration.Seconds() < FETCH_DELAY_SEC {
-		time.Sleep(time.Duration(FETCH_DELAY_SEC) * time.Second)
-	}
-
-	url = fmt.Sprintf(url, y, m, d)
-	fmt.Printf("Fetching %s (%d/%d/%d)...\n", url, y, m, d)
-	if stkType == DATA_TYPE_TWSE {
-		lastTWSEFetchTime = time.Now()
-	} else if stkType == DATA_TYPE_TPEX {
-		lastTPExFetchTime = time.Now()
-	}
-
-	resp, err := http.Get(url)
-	if err != nil {
-		fmt.Printf("http get failed = %s\n", err.Error())
-		return err
+// Whether (day, market) still has to be fetched.
+func needFetch(logs map[string]mydb.FetchLog, day mydb.Date, market string) bool {
+	l, exist := logs[day.String()+market]
+	if !exist {
+		return true
 	}
-	defer resp.Body.Close()
+	return l.Status == mydb.FETCH_FAILED && l.Attempts < conf.FetchAttempts
+}
 
-	body, err := io.ReadAll(resp.Body)
+// Fetch every (date, market) pair in the window that is missing or failed.
+// A network error stops the run; what was fetched so far stays recorded and
+// summed up in sum.
+func updateFetch(ctx context.Context) (sum FetchSummary, err error) {
+	today := mydb.DateOf(time.Now().In(taipei))
+	days, logs, err := fetchWindow()
 	if err != nil {
-		fmt.Println("Read error:", err)
-		return err
+		return sum, err
 	}
 
-	switch stkType {
-	case DATA_TYPE_TWSE:
-		var report TWSEReport
-		err = json.Unmarshal(body, &report)
-		if err != nil {
-			return err
-		}
-		if report.Tables == nil {
-			return ErrNoEntry
-		}
-		for _, ent := range report.Tables {
-			if !strings.Contains(ent.Title, "每日收盤行情") {
+	for _, day := range days {
+		misses := 0
+		for _, p := range quoteProviders {
+			market := p.Market()
+			if !needFetch(logs, day, market) {
 				continue
 			}
-			for _, data := range ent.Data {
-				err = saveDailyQuote(DATA_TYPE_TWSE, data, y, m, d)
-				if err != nil {
-					break
+
+			daySum, err := fetchDay(ctx, p, day, false)
+			sum.Add(daySum)
+			if ctx.Err() != nil {
+				// Shutting down; this pair is not at fault.
+				return sum, ctx.Err()
+			}
+			if err != nil {
+				mydb.RecordFetch(day, market, mydb.FETCH_FAILED, daySum.Stored, err)
+				if err == ErrNoEntry {
+					misses += 1
+					continue
 				}
+				return sum, err
+			}
+			if err = mydb.RecordFetch(day, market, mydb.FETCH_OK, daySum.Stored, nil); err != nil {
+				return sum, err
 			}
 		}
-	case DATA_TYPE_TPEX:
-		var report TPExReport
-		err = json.Unmarshal(body, &report)
-		if err != nil {
-			return err
-		}
-		if report.Tables[0].TotalCount == 0 {
-			return ErrNoEntry
+
+		// A weekday neither exchange reported is a closure the calendar
+		// missed. One market alone missing a report is retried instead.
+		closed := misses == len(quoteProviders) && day.Before(today)
+		if closed {
+			fmt.Printf("No report for %s, marking it closed\n", day)
+			if err = mydb.LearnClosed(day, "no TWSE or TPEx report"); err != nil {
+				return sum, err
+			}
+			for _, m := range fetchMarkets() {
+				mydb.RecordFetch(day, m, mydb.FETCH_CLOSED, 0, nil)
+			}
 		}
-		for _, ent := range report.Tables {
-			if !strings.Contains(ent.Title, "上櫃股票每日收盤行情") {
+
+		for _, s := range sideReports {
+			if closed || !needFetch(logs, day, s.market) {
 				continue
 			}
-			for _, data := range ent.Data {
-				err = saveDailyQuote(DATA_TYPE_TPEX, data, y, m, d)
-				if err != nil {
-					break
-				}
+			rowNr, err := s.fetch(ctx, day, day)
+			if ctx.Err() != nil {
+				return sum, ctx.Err()
+			}
+			if err != nil {
+				// Left for the next run; the quotes of later days go on.
+				fmt.Printf("Fetch %s %s failed: %s\n", s.market, day, err.Error())
+				mydb.RecordFetch(day, s.market, mydb.FETCH_FAILED, rowNr, err)
+				continue
+			}
+			if err = mydb.RecordFetch(day, s.market, mydb.FETCH_OK, rowNr, nil); err != nil {
+				return sum, err
 			}
 		}
 	}
+	return sum, updateRevenue(ctx, logs)
+}
+
+// Every market in the fetch log.
+func fetchMarkets() []string {
+	markets := []string{MARKET_TWSE, MARKET_TPEX}
+	for _, s := range sideReports {
+		markets = append(markets, s.market)
+	}
+	return markets
+}
 
+type FetchGap struct {
+	Date     mydb.Date `json:"date"`
+	Market   string    `json:"market"`
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Status of a failed pair the fetcher no longer retries.
+const FETCH_GAVE_UP = "exhausted"
+
+// Trading days in the fetch window without a good fetch of some market. Failed
+// pairs out of attempts are marked exhausted; they stay so until retried by an
+// admin.
+func fetchGaps() ([]FetchGap, error) {
+	days, logs, err := fetchWindow()
 	if err != nil {
-		fmt.Printf("Unmarshal data type=%d error = %s\n", stkType, err.Error())
-		return err
+		return nil, err
+	}
+	gaps := []FetchGap{}
+	for _, day := range days {
+		for _, market := range fetchMarkets() {
+			l, exist := logs[day.String()+market]
+			if !exist {
+				gaps = append(gaps, FetchGap{Date: day, Market: market, Status: "missing"})
+			} else if l.Status == mydb.FETCH_FAILED {
+				status := l.Status
+				if !needFetch(logs, day, market) {
+					status = FETCH_GAVE_UP
+				}
+				gaps = append(gaps, FetchGap{Date: day, Market: market, Status: status, Attempts: l.Attempts, Error: l.Error})
+			}
+		}
 	}
+	return gaps, nil
+}
 
-	fmt.Printf("Save DQ %d/%d/%d for type%d Complete\n", y, m, d, stkType)
-	return err
+// FetchSummary tells what became of the rows of the daily reports fetched.
+type FetchSummary struct {
+	Stored   int           `json:"stored"`
+	Skipped  int           `json:"skipped"`
+	Rejected int           `json:"rejected"`
+	Rejects  []mydb.Reject `json:"rejects,omitempty"`
 }
 
-func saveDailyQuote(stkType int, data []string, y int, m int, d int) error {
-	var code string
-	var dq mydb.DaliyQuote
-	var err error
+func (s *FetchSummary) Add(o FetchSummary) {
+	s.Stored += o.Stored
+	s.Skipped += o.Skipped
+	s.Rejected += o.Rejected
+	s.Rejects = append(s.Rejects, o.Rejects...)
+}
 
-	switch stkType {
-	case DATA_TYPE_TWSE:
-		code, dq, err = formatTWSE(data, y, m, d)
-	case DATA_TYPE_TPEX:
-		code, dq, err = formatTPEx(data, y, m, d)
-	default:
-		return errors.New("invalid stock type")
+// fetchDay stores the bars of one market on one day, and the rows that could
+// not be read into the rejects table. Stored bars of that day are kept unless
+// overwrite is set. Only a failure of the report or the database is an error.
+func fetchDay(ctx context.Context, p QuoteProvider, d mydb.Date, overwrite bool) (sum FetchSummary, err error) {
+	rep, err := p.DailyBars(ctx, d)
+	if err != nil {
+		return sum, err
+	}
+	sum.Skipped = rep.Skipped
+	stored, rejects, err := saveBars(p.Market(), rep.Bars, overwrite)
+	if err != nil {
+		return sum, err
+	}
+	sum.Stored = stored
+	sum.Rejects = append(rep.Rejects, rejects...)
+	sum.Rejected = len(sum.Rejects)
+	if sum.Rejected > 0 {
+		if err = mydb.PutRejects(sum.Rejects); err != nil {
+			return sum, err
+		}
 	}
+	fmt.Printf("Save DQ %s for %s Complete: %d stored, %d skipped, %d rejected\n",
+		d, p.Market(), sum.Stored, sum.Skipped, sum.Rejected)
+	return sum, nil
+}
+
+// Reject of a report row that failed with err, the code taken from its first
+// field. The market is left to the caller.
+func newReject(d mydb.Date, data []string, err error) mydb.Reject {
+	code := ""
+	if len(data) > 0 {
+		code = strings.TrimSpace(data[0])
+	}
+	return mydb.Reject{Date: d, Code: code, Row: strings.Join(data, "|"), Reason: err.Error()}
+}
+
+// Reject of a report row of market that failed with err.
+func rejectRow(market string, d mydb.Date, data []string, err error) mydb.Reject {
+	r := newReject(d, data, err)
+	r.Market = market
+	fmt.Printf("Reject %s row %s: %s\n", market, r.Code, r.Reason)
+	return r
+}
 
+// Reject of a parsed bar the database refused.
+func rejectBar(market string, b Bar, err error) mydb.Reject {
+	q := b.Quote
+	data := []string{b.Code, b.Name, strconv.FormatInt(q.Volume, 10), strconv.Itoa(q.Trans),
+		strconv.FormatInt(q.Value, 10), q.Open.String(), q.High.String(), q.Low.String(), q.Close.String()}
+	return rejectRow(market, q.Date, data, err)
+}
+
+// Sort one formatted row into rep: warrants and malformed codes are skipped,
+// a row failing otherwise is rejected.
+func addRow(rep *DayReport, market string, d mydb.Date, data []string, b Bar, err error) {
 	if err == ErrNotStock {
-		return nil
+		rep.Skipped++
+	} else if mydb.IsValidationError(err) {
+		fmt.Printf("Skip row: %s\n", err.Error())
+		rep.Skipped++
 	} else if err != nil {
-		log.Fatalf("Error: %s\n", err.Error())
-		return err
+		rep.Rejects = append(rep.Rejects, rejectRow(market, d, data, err))
+	} else {
+		rep.Bars = append(rep.Bars, b)
 	}
+}
 
-	fmt.Printf("Processing %s...\r", code)
-	existDQArr, err := mydb.GetDailyQuote(mydb.STKPREFIX+code, 1)
-	if err != nil && err != mydb.ErrNoSuchTable {
-		fmt.Printf("Error: %s\n", err.Error())
-		return err
+func parseTWSE(body []byte, d mydb.Date) (rep DayReport, err error) {
+	var report TWSEReport
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		return rep, err
 	}
-
-	if err != mydb.ErrNoSuchTable {
-		existDQ := existDQArr[0]
-		if existDQ.Day == d && existDQ.Month == m && existDQ.Year == y {
-			// Already exist.
-			return nil
+	if report.Tables == nil {
+		return rep, ErrNoEntry
+	}
+	for _, ent := range report.Tables {
+		if !strings.Contains(ent.Title, "每日收盤行情") {
+			continue
+		}
+		for _, data := range ent.Data {
+			b, err := formatTWSE(data, d)
+			addRow(&rep, MARKET_TWSE, d, data, b, err)
 		}
+	}
+	return rep, nil
+}
 
-		if dq.Volume == 0 {
-			dq.Open = existDQ.Close
-			dq.High = existDQ.Close
-			dq.Low = existDQ.Close
-			dq.Close = existDQ.Close
+func parseTPEx(body []byte, d mydb.Date) (rep DayReport, err error) {
+	var report TPExReport
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		return rep, err
+	}
+	if len(report.Tables) == 0 || report.Tables[0].TotalCount == 0 {
+		return rep, ErrNoEntry
+	}
+	for _, ent := range report.Tables {
+		if !strings.Contains(ent.Title, "上櫃股票每日收盤行情") {
+			continue
+		}
+		for _, data := range ent.Data {
+			b, err := formatTPEx(data, d)
+			addRow(&rep, MARKET_TPEX, d, data, b, err)
 		}
 	}
+	return rep, nil
+}
 
-	err = mydb.AddDailyQuote(code, &dq)
+// saveBars stores the bars of one market on one day, or of one stock over a
+// month, in one transaction, so a failure leaves no part of them behind. Bars
+// with a bad code are rejected instead.
+func saveBars(market string, bars []Bar, overwrite bool) (stored int, rejects []mydb.Reject, err error) {
+	named, err := mydb.RefCodes()
 	if err != nil {
-		log.Fatalf("Error: %s\n", err.Error())
-		return err
+		return 0, nil, err
 	}
-	return err
+	list := make([]mydb.StockQuote, 0, len(bars))
+	newNames := map[string]string{}
+	for _, b := range bars {
+		if err = mydb.ValidateCode(b.Code); err != nil {
+			rejects = append(rejects, rejectBar(market, b, err))
+			continue
+		}
+		if !named[b.Code] && b.Name != "" {
+			newNames[b.Code] = b.Name
+		}
+		list = append(list, mydb.StockQuote{Code: b.Code, DaliyQuote: b.Quote})
+	}
+	if len(newNames) > 0 {
+		if err = mydb.AddRefs(newNames); err != nil {
+			fmt.Printf("Failed to Add Refs of %s: %s\n", market, err.Error())
+		}
+	}
+	stored, err = mydb.PutDailyQuotes(list, overwrite)
+	return stored, rejects, err
 }
 
-func formatTWSE(data []string, y int, m int, d int) (string, mydb.DaliyQuote, error) {
-	var open, high, low, close float64
+func formatTWSE(data []string, d mydb.Date) (Bar, error) {
+	var open, high, low, close mydb.Price
 	var volume, trans, tval int64
 	var err error
 
@@ -270,13 +389,20 @@ func formatTWSE(data []string, y int, m int, d int) (string, mydb.DaliyQuote, er
 		IDX_PE           = 15
 	)
 
-	dq := mydb.DaliyQuote{Year: y, Month: m, Day: d}
+	if len(data) <= IDX_CLOSE {
+		return Bar{}, fmt.Errorf("short row of %d fields", len(data))
+	}
+	dq := mydb.DaliyQuote{Date: d}
 
 	code := data[IDX_CODE]
+	name := strings.TrimSpace(data[IDX_NAME])
 	// fmt.Printf("Formating %s...\n", code)
 
 	if isWarrant(code) {
-		return code, dq, ErrNotStock
+		return Bar{}, ErrNotStock
+	}
+	if err = mydb.ValidateCode(code); err != nil {
+		return Bar{}, err
 	}
 
 	if strings.Contains(data[IDX_CLOSE], "--") {
@@ -292,41 +418,32 @@ func formatTWSE(data []string, y int, m int, d int) (string, mydb.DaliyQuote, er
 
 	volume, err = strconv.ParseInt(strings.Replace(data[IDX_VOLUME], ",", "", -1), 10, 64)
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
 
 	trans, err = strconv.ParseInt(strings.Replace(data[IDX_TRANS_NR], ",", "", -1), 10, 64)
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
 	tval, err = strconv.ParseInt(strings.Replace(data[IDX_TARANS_VALUE], ",", "", -1), 10, 64)
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
-	open, err = strconv.ParseFloat(strings.Replace(data[IDX_OPEN], ",", "", -1), 64)
+	open, err = mydb.ParsePrice(data[IDX_OPEN])
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
-	high, err = strconv.ParseFloat(strings.Replace(data[IDX_HIGH], ",", "", -1), 64)
+	high, err = mydb.ParsePrice(data[IDX_HIGH])
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
-	low, err = strconv.ParseFloat(strings.Replace(data[IDX_LOW], ",", "", -1), 64)
+	low, err = mydb.ParsePrice(data[IDX_LOW])
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
-	close, err = strconv.ParseFloat(strings.Replace(data[IDX_CLOSE], ",", "", -1), 64)
+	close, err = mydb.ParsePrice(data[IDX_CLOSE])
 	if err != nil {
-		return code, dq, err
-	}
-
-	_, err = mydb.RefLookupNameByCode(code)
-	if err == nil {
-		goto finish
-	}
-	err = mydb.AddRef(code, data[IDX_NAME])
-	if err != nil {
-		fmt.Printf("Failed to Add Ref! for %s -> %s\n", code, data[IDX_NAME])
+		return Bar{}, err
 	}
 
 finish:
@@ -337,11 +454,11 @@ finish:
 	dq.Close = close
 	dq.High = high
 	dq.Low = low
-	return code, dq, nil
+	return Bar{Code: code, Name: name, Quote: dq}, nil
 }
 
-func formatTPEx(data []string, y int, m int, d int) (string, mydb.DaliyQuote, error) {
-	var open, high, low, close float64
+func formatTPEx(data []string, d mydb.Date) (Bar, error) {
+	var open, high, low, close mydb.Price
 	var volume, trans, tval int64
 	var err error
 
@@ -365,13 +482,20 @@ func formatTPEx(data []string, y int, m int, d int) (string, mydb.DaliyQuote, er
 		IDX_NXT_D_LOWEST   = 16
 	)
 
-	dq := mydb.DaliyQuote{Year: y, Month: m, Day: d}
+	if len(data) <= IDX_TRANS_NR {
+		return Bar{}, fmt.Errorf("short row of %d fields", len(data))
+	}
+	dq := mydb.DaliyQuote{Date: d}
 
 	code := data[IDX_CODE]
+	name := strings.TrimSpace(data[IDX_NAME])
 	// fmt.Printf("Formating %s...\n", code)
 
 	if isWarrant(code) {
-		return code, dq, ErrNotStock
+		return Bar{}, ErrNotStock
+	}
+	if err = mydb.ValidateCode(code); err != nil {
+		return Bar{}, err
 	}
 
 	if strings.Contains(data[IDX_CLOSE], "--") {
@@ -387,41 +511,32 @@ func formatTPEx(data []string, y int, m int, d int) (string, mydb.DaliyQuote, er
 
 	volume, err = strconv.ParseInt(strings.Replace(data[IDX_VOLUME], ",", "", -1), 10, 64)
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
 
 	trans, err = strconv.ParseInt(strings.Replace(data[IDX_TRANS_NR], ",", "", -1), 10, 64)
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
 	tval, err = strconv.ParseInt(strings.Replace(data[IDX_TARANS_VALUE], ",", "", -1), 10, 64)
 	if err != nil {
-		return code, dq, err
-	}
-	open, err = strconv.ParseFloat(strings.Replace(data[IDX_OPEN], ",", "", -1), 64)
-	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
-	high, err = strconv.ParseFloat(strings.Replace(data[IDX_HIGH], ",", "", -1), 64)
+	open, err = mydb.ParsePrice(data[IDX_OPEN])
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
-	low, err = strconv.ParseFloat(strings.Replace(data[IDX_LOW], ",", "", -1), 64)
+	high, err = mydb.ParsePrice(data[IDX_HIGH])
 	if err != nil {
-		return code, dq, err
+		return Bar{}, err
 	}
-	close, err = strconv.ParseFloat(strings.Replace(data[IDX_CLOSE], ",", "", -1), 64)
+	low, err = mydb.ParsePrice(data[IDX_LOW])
 	if err != nil {
-		return code, dq, err
-	}
-
-	_, err = mydb.RefLookupNameByCode(code)
-	if err == nil {
-		goto finish
+		return Bar{}, err
 	}
-	err = mydb.AddRef(code, data[IDX_NAME])
+	close, err = mydb.ParsePrice(data[IDX_CLOSE])
 	if err != nil {
-		fmt.Printf("Failed to Add Ref! for %s -> %s\n", code, data[IDX_NAME])
+		return Bar{}, err
 	}
 
 finish:
@@ -439,7 +554,7 @@ finish:
 	dq.Close = close
 	dq.High = high
 	dq.Low = low
-	return code, dq, nil
+	return Bar{Code: code, Name: name, Quote: dq}, nil
 }
 
 func isWarrant(code string) bool {
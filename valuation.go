@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	mydb "myDatabase"
+)
+
+// Fetch log markets of the valuation reports.
+const MARKET_TWSE_VALUE string = "twse-value"
+const MARKET_TPEX_VALUE string = "tpex-value"
+
+// Most percentile of the valuation scan by default.
+const DEFAULT_VALUATION_PERCENTILE float64 = 20
+
+// A blank metric, shown as "-" or "N/A", is 0.
+func parseMetric(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "-" || s == "N/A" {
+		return 0, nil
+	}
+	return parseNum(s)
+}
+
+// Both markets name the columns alike, in different orders.
+func parseValuation(body []byte, d mydb.Date) (list []mydb.Valuation, rejects []mydb.Reject, err error) {
+	tables, err := sideTables(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tbl := range tables {
+		idxCode := fieldIndex(tbl.Fields, "代號")
+		idxPE := fieldIndex(tbl.Fields, "本益比")
+		idxPB := fieldIndex(tbl.Fields, "股價淨值比")
+		idxYield := fieldIndex(tbl.Fields, "殖利率")
+		if idxCode < 0 || idxPE < 0 || idxPB < 0 || idxYield < 0 {
+			return nil, nil, fmt.Errorf("unknown valuation layout %q", tbl.Fields)
+		}
+		for _, data := range tbl.Data {
+			if len(data) < len(tbl.Fields) {
+				rejects = append(rejects, newReject(d, data, fmt.Errorf("short row of %d fields", len(data))))
+				continue
+			}
+			v := mydb.Valuation{Date: d, Code: strings.TrimSpace(data[idxCode])}
+			if err = mydb.ValidateCode(v.Code); err != nil {
+				fmt.Printf("Skip row: %s\n", err.Error())
+				continue
+			}
+			var errs [3]error
+			v.PE, errs[0] = parseMetric(data[idxPE])
+			v.PB, errs[1] = parseMetric(data[idxPB])
+			v.Yield, errs[2] = parseMetric(data[idxYield])
+			if err = errors.Join(errs[:]...); err != nil {
+				rejects = append(rejects, newReject(d, data, err))
+				continue
+			}
+			list = append(list, v)
+		}
+	}
+	return list, rejects, nil
+}
+
+// Start of the history a valuation is ranked in.
+func valuationSince(years int) mydb.Date {
+	return mydb.DateOf(time.Now().In(taipei).AddDate(-years, 0, 0))
+}
+
+// GET /valuation?code=2330&metric=pe&years=5 ranks the latest metric of code
+// within its own range of the last years.
+func valuationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		metric = mydb.METRIC_PE
+	}
+	years := conf.ValuationYears
+	if str := q.Get("years"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 {
+			writeJSONErrResonse(w, "Invalid years", http.StatusBadRequest)
+			return
+		}
+		years = n
+	}
+
+	rank, err := mydb.RankValuation(q.Get("code"), metric, valuationSince(years))
+	if err == mydb.ErrNoValuation {
+		writeJSONErrResonse(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJSONErrResonse(w, err.Error(), dbErrStatus(err))
+		return
+	}
+	writeJSONOKResonse(w, rank)
+}
+
+// Stocks whose metric ranks within the cheapest maxPct percent of its own
+// history: low PE or PB, high yield.
+func findValuation(metric string, maxPct float64, tblName string, interval int, dqs []mydb.DaliyQuote, rank mydb.ValuationRank) (Result, error) {
+	if maxPct <= 0 {
+		maxPct = DEFAULT_VALUATION_PERCENTILE
+	}
+	totalLen := len(dqs)
+	if totalLen < interval+conf.BaseQDsNr {
+		return Result{}, ErrTooFewDays
+	}
+	if dqs[totalLen-1].Volume < conf.MinInterestedVol {
+		return Result{}, ErrNotInsterested
+	}
+	if rank.Samples == 0 {
+		return Result{}, ErrNotInsterested
+	}
+	cheap := rank.Percentile <= maxPct
+	if metric == mydb.METRIC_YIELD {
+		cheap = rank.Percentile >= 100-maxPct
+	}
+	if !cheap {
+		return Result{}, ErrNotInsterested
+	}
+
+	config := genTrendChart(tblName, dqs)
+	info := fmt.Sprintf("%s=%.2f p%.0f (%.2f-%.2f)", metric, rank.Value, rank.Percentile, rank.Min, rank.Max)
+	return Result{Config: config, Info: info}, nil
+}